@@ -1,21 +1,34 @@
 package clients
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	scryfall "github.com/BlueMonday/go-scryfall"
 	"github.com/BrandonWade/blackblade-batch/models"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	downloadMaxAttempts = 5
+	partialFileSuffix   = ".part"
+)
+
+// downloadInitialBackoff is the delay before retrying a failed download, doubled after each attempt
+var downloadInitialBackoff = 2 * time.Second
+
 // ScryfallClient interface for working with a scryfallClient.
 type ScryfallClient interface {
-	GetBulkData(dataType string) (models.ScryfallBulkData, error)
-	DownloadBulkData(dataType, uri, filepath string) error
+	GetBulkData(ctx context.Context, dataType string) (models.ScryfallBulkData, error)
+	DownloadBulkData(ctx context.Context, data models.ScryfallBulkData, filepath string) error
 }
 
 type scryfallClient struct {
@@ -34,15 +47,24 @@ func NewScryfallClient(baseURL string, logger *logrus.Logger, client *scryfall.C
 }
 
 // GetBulkData returns the bulk data of the specified type from the Scryfall API.
-func (s *scryfallClient) GetBulkData(dataType string) (models.ScryfallBulkData, error) {
+func (s *scryfallClient) GetBulkData(ctx context.Context, dataType string) (models.ScryfallBulkData, error) {
 	url := fmt.Sprintf("%s/bulk-data/%s", s.baseURL, dataType)
 
-	res, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return models.ScryfallBulkData{}, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return models.ScryfallBulkData{}, err
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return models.ScryfallBulkData{}, fmt.Errorf("unexpected status fetching %s bulk data: %s", dataType, res.Status)
+	}
+
 	data := models.ScryfallBulkData{}
 	err = json.NewDecoder(res.Body).Decode(&data)
 	if err != nil {
@@ -53,25 +75,186 @@ func (s *scryfallClient) GetBulkData(dataType string) (models.ScryfallBulkData,
 }
 
 // DownloadBulkData downloads the contents of the specified bulk data file from the Scryfall API.
-func (s *scryfallClient) DownloadBulkData(dataType, uri, filepath string) error {
-	res, err := http.Get(uri)
+// The file is downloaded to a partial file first, resuming any previous partial download, and is
+// only moved to filepath once its size has been verified. The file is stored exactly as it was
+// served, so it may be gzip compressed. Cancelling ctx stops the download, keeping the partial file.
+func (s *scryfallClient) DownloadBulkData(ctx context.Context, data models.ScryfallBulkData, filepath string) error {
+	partialPath := filepath + partialFileSuffix
+	backoff := downloadInitialBackoff
+
+	var err error
+	for attempt := 1; attempt <= downloadMaxAttempts; attempt++ {
+		var retryable bool
+		retryable, err = s.downloadPartialFile(ctx, data, partialPath)
+		if err == nil {
+			break
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !retryable || attempt == downloadMaxAttempts {
+			return fmt.Errorf("error downloading %s bulk data file after %d attempt(s): %s", data.Type, attempt, err.Error())
+		}
+
+		s.logger.Warnf("error downloading %s bulk data file (attempt %d of %d), retrying in %s: %s", data.Type, attempt, downloadMaxAttempts, backoff, err.Error())
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+
+	err = os.Rename(partialPath, filepath)
 	if err != nil {
 		return err
 	}
+
+	s.logger.Printf("Successfully downloaded %s bulk data file %s", data.Type, filepath)
+
+	return nil
+}
+
+// downloadPartialFile downloads the bulk data file to partialPath, resuming from the end of any
+// existing partial file, and verifies the size of the result. It returns whether a failed download
+// is worth retrying.
+func (s *scryfallClient) downloadPartialFile(ctx context.Context, data models.ScryfallBulkData, partialPath string) (bool, error) {
+	var offset int64
+	info, err := os.Stat(partialPath)
+	if err == nil {
+		offset = info.Size()
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, data.DownloadURI, nil)
+	if err != nil {
+		return false, err
+	}
+
+	// Request the compressed representation explicitly so that it is not transparently decoded,
+	// which keeps byte ranges and sizes consistent with the file as it is stored by Scryfall.
+	req.Header.Set("Accept-Encoding", "gzip")
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return true, err
+	}
 	defer res.Body.Close()
 
-	out, err := os.Create(filepath)
+	flags := os.O_CREATE | os.O_WRONLY
+	var total int64
+	switch res.StatusCode {
+	case http.StatusOK:
+		// The server ignored the range request (or there was none), so start over
+		flags |= os.O_TRUNC
+		offset = 0
+		total = res.ContentLength
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+		total, err = parseContentRangeTotal(res.Header.Get("Content-Range"))
+		if err != nil {
+			return false, err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file may already be complete, e.g. if the last attempt stopped before it was moved into place
+		total, err = parseContentRangeTotal(res.Header.Get("Content-Range"))
+		if err != nil {
+			total = -1
+		}
+
+		expected := expectedFileSize(data, res.Header.Get("Content-Encoding"), res.Header.Get("Content-Type"))
+		if offset > 0 && (offset == total || (total < 0 && offset == expected)) {
+			s.logger.Printf("%s bulk data file was already completely downloaded", data.Type)
+			return s.verifyFileSize(data, res, partialPath, offset)
+		}
+
+		// Otherwise the partial file is no longer consistent with the remote file, so discard it
+		err = os.Remove(partialPath)
+		if err != nil {
+			return false, err
+		}
+
+		return true, errors.New("partial download could not be resumed")
+	default:
+		retryable := res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusRequestTimeout
+		return retryable, fmt.Errorf("unexpected status downloading bulk data file: %s", res.Status)
+	}
+
+	if offset > 0 {
+		s.logger.Printf("Resuming %s bulk data file download at byte %d", data.Type, offset)
+	}
+
+	out, err := os.OpenFile(partialPath, flags, 0644)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer out.Close()
 
-	_, err = io.Copy(out, res.Body)
+	written, err := io.Copy(out, res.Body)
 	if err != nil {
-		return err
+		return true, err
 	}
 
-	s.logger.Printf("Successfully downloaded %s bulk data file %s", dataType, filepath)
+	size := offset + written
+	if total >= 0 && size != total {
+		return true, fmt.Errorf("downloaded %d bytes but expected %d", size, total)
+	}
 
-	return nil
+	return s.verifyFileSize(data, res, partialPath, size)
+}
+
+// verifyFileSize checks the size of a downloaded file against the size Scryfall reports for it, discarding the file
+// if they differ. It returns whether the failed download is worth retrying.
+func (s *scryfallClient) verifyFileSize(data models.ScryfallBulkData, res *http.Response, partialPath string, size int64) (bool, error) {
+	expected := expectedFileSize(data, res.Header.Get("Content-Encoding"), res.Header.Get("Content-Type"))
+	if expected <= 0 || size == expected {
+		return false, nil
+	}
+
+	err := os.Remove(partialPath)
+	if err != nil {
+		return false, err
+	}
+
+	return true, fmt.Errorf("downloaded %d bytes but bulk data reports a size of %d", size, expected)
+}
+
+// expectedFileSize returns the size Scryfall reports for the file as it was served, or 0 if it reports none. A
+// file served with a gzip Content-Encoding is stored compressed, so it matches the compressed size. A file served
+// as is, such as a .json.gz file served as application/gzip, matches the size of the file itself.
+func expectedFileSize(data models.ScryfallBulkData, contentEncoding, contentType string) int64 {
+	if contentEncoding == "gzip" {
+		return data.CompressedSize
+	}
+
+	if data.Size > 0 {
+		return data.Size
+	}
+
+	if strings.HasPrefix(contentType, "application/gzip") || strings.HasPrefix(contentType, "application/x-gzip") {
+		return data.CompressedSize
+	}
+
+	return 0
+}
+
+// parseContentRangeTotal returns the complete length from a Content-Range header, or -1 if it is unknown.
+func parseContentRangeTotal(contentRange string) (int64, error) {
+	i := strings.LastIndex(contentRange, "/")
+	if !strings.HasPrefix(contentRange, "bytes ") || i < 0 {
+		return 0, fmt.Errorf("invalid Content-Range header %q", contentRange)
+	}
+
+	total := contentRange[i+1:]
+	if total == "*" {
+		return -1, nil
+	}
+
+	return strconv.ParseInt(total, 10, 64)
 }
@@ -0,0 +1,265 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+	"github.com/sirupsen/logrus"
+)
+
+const testBulkData = `[{"object":"card","name":"Goblin Guide"},{"object":"card","name":"Lightning Bolt"}]`
+
+// serveRange serves content, honouring a Range header of the form bytes=N-.
+func serveRange(w http.ResponseWriter, r *http.Request, content string) {
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" {
+		io.WriteString(w, content)
+		return
+	}
+
+	offset, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rangeHeader, "bytes="), "-"))
+	if err != nil || offset >= len(content) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(content)))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(content)-1, len(content)))
+	w.WriteHeader(http.StatusPartialContent)
+	io.WriteString(w, content[offset:])
+}
+
+func TestDownloadBulkData(t *testing.T) {
+	backoff := downloadInitialBackoff
+	downloadInitialBackoff = time.Millisecond
+	defer func() {
+		downloadInitialBackoff = backoff
+	}()
+
+	tests := []struct {
+		name string
+		// partial is the content of the partial file left by a previous download, if any
+		partial        string
+		size           int64
+		compressedSize int64
+		handler        func(w http.ResponseWriter, r *http.Request)
+		wantRanges     []string
+		wantErr        bool
+		wantContent    string
+	}{
+		{
+			name: "new download",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				serveRange(w, r, testBulkData)
+			},
+			wantRanges:  []string{""},
+			wantContent: testBulkData,
+		},
+		{
+			name:    "resumed download",
+			partial: testBulkData[:20],
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				serveRange(w, r, testBulkData)
+			},
+			wantRanges:  []string{"bytes=20-"},
+			wantContent: testBulkData,
+		},
+		{
+			name:    "range ignored",
+			partial: testBulkData[:20],
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, testBulkData)
+			},
+			wantRanges:  []string{"bytes=20-"},
+			wantContent: testBulkData,
+		},
+		{
+			name:    "range not satisfiable",
+			partial: testBulkData + "stale",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				serveRange(w, r, testBulkData)
+			},
+			wantRanges:  []string{fmt.Sprintf("bytes=%d-", len(testBulkData)+5), ""},
+			wantContent: testBulkData,
+		},
+		{
+			name:    "partial file already complete",
+			partial: testBulkData,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				serveRange(w, r, testBulkData)
+			},
+			wantRanges:  []string{fmt.Sprintf("bytes=%d-", len(testBulkData))},
+			wantContent: testBulkData,
+		},
+		{
+			name:    "partial file already complete without content range",
+			partial: testBulkData,
+			size:    int64(len(testBulkData)),
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			},
+			wantRanges:  []string{fmt.Sprintf("bytes=%d-", len(testBulkData))},
+			wantContent: testBulkData,
+		},
+		{
+			name:    "content range length mismatch",
+			partial: testBulkData[:20],
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 20-%d/%d", len(testBulkData)-1, len(testBulkData)+10))
+				w.WriteHeader(http.StatusPartialContent)
+				io.WriteString(w, testBulkData[20:])
+			},
+			wantRanges: []string{
+				"bytes=20-",
+				fmt.Sprintf("bytes=%d-", len(testBulkData)),
+				fmt.Sprintf("bytes=%d-", 2*len(testBulkData)-20),
+				fmt.Sprintf("bytes=%d-", 3*len(testBulkData)-40),
+				fmt.Sprintf("bytes=%d-", 4*len(testBulkData)-60),
+			},
+			wantErr: true,
+		},
+		{
+			name: "truncated body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", strconv.Itoa(len(testBulkData)+10))
+				io.WriteString(w, testBulkData)
+			},
+			wantRanges: []string{
+				"",
+				fmt.Sprintf("bytes=%d-", len(testBulkData)),
+				fmt.Sprintf("bytes=%d-", len(testBulkData)),
+				fmt.Sprintf("bytes=%d-", len(testBulkData)),
+				fmt.Sprintf("bytes=%d-", len(testBulkData)),
+			},
+			wantErr: true,
+		},
+		{
+			name:           "compressed size mismatch",
+			compressedSize: int64(len(testBulkData)) + 10,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				serveRange(w, r, testBulkData)
+			},
+			wantRanges: []string{"", "", "", "", ""},
+			wantErr:    true,
+		},
+		{
+			name: "gzip file with the reported size",
+			size: int64(len(testBulkData)),
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/gzip")
+				serveRange(w, r, testBulkData)
+			},
+			wantRanges:  []string{""},
+			wantContent: testBulkData,
+		},
+		{
+			name: "gzip file size mismatch",
+			size: int64(len(testBulkData)) - 10,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/gzip")
+				serveRange(w, r, testBulkData)
+			},
+			wantRanges: []string{"", "", "", "", ""},
+			wantErr:    true,
+		},
+		{
+			name: "not found",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			},
+			wantRanges: []string{""},
+			wantErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var ranges []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ranges = append(ranges, r.Header.Get("Range"))
+				test.handler(w, r)
+			}))
+			defer server.Close()
+
+			path := filepath.Join(t.TempDir(), "bulk.json")
+			if test.partial != "" {
+				err := os.WriteFile(path+partialFileSuffix, []byte(test.partial), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+			client := NewScryfallClient(server.URL, logger, nil)
+
+			data := models.ScryfallBulkData{
+				Type:           "default_cards",
+				DownloadURI:    server.URL + "/default-cards.json",
+				Size:           test.size,
+				CompressedSize: test.compressedSize,
+			}
+			err := client.DownloadBulkData(context.Background(), data, path)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+
+			if !reflect.DeepEqual(ranges, test.wantRanges) {
+				t.Errorf("got range headers %q, want %q", ranges, test.wantRanges)
+			}
+
+			content, err := os.ReadFile(path)
+			if test.wantErr {
+				if !os.IsNotExist(err) {
+					t.Errorf("got error %v reading the bulk data file of a failed download, want it not to exist", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(content) != test.wantContent {
+				t.Errorf("got content %q, want %q", content, test.wantContent)
+			}
+
+			_, err = os.Stat(path + partialFileSuffix)
+			if !os.IsNotExist(err) {
+				t.Errorf("got error %v checking the partial file of a finished download, want it not to exist", err)
+			}
+		})
+	}
+}
+
+func TestDownloadBulkDataCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	client := NewScryfallClient(server.URL, logger, nil)
+
+	data := models.ScryfallBulkData{
+		Type:        "default_cards",
+		DownloadURI: server.URL + "/default-cards.json",
+	}
+	err := client.DownloadBulkData(ctx, data, filepath.Join(t.TempDir(), "bulk.json"))
+	if err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
@@ -1,12 +1,20 @@
 module github.com/BrandonWade/blackblade-batch
 
-go 1.12
+go 1.18
 
 require (
 	github.com/BlueMonday/go-scryfall v0.1.0
+	github.com/DATA-DOG/go-sqlmock v1.5.2
 	github.com/go-sql-driver/mysql v1.4.1
-	github.com/gobuffalo/nulls v0.4.0
 	github.com/jmoiron/sqlx v1.2.0
 	github.com/sirupsen/logrus v1.4.2
+)
+
+require (
+	github.com/google/go-querystring v1.0.0 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	github.com/mattn/go-sqlite3 v2.0.3+incompatible // indirect
+	github.com/stretchr/testify v1.5.1 // indirect
+	golang.org/x/sys v0.0.0-20190422165155-953cdadca894 // indirect
 	google.golang.org/appengine v1.6.6 // indirect
 )
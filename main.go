@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	scryfall "github.com/BlueMonday/go-scryfall"
 	"github.com/BrandonWade/blackblade-batch/clients"
 	"github.com/BrandonWade/blackblade-batch/repositories"
+	"github.com/BrandonWade/blackblade-batch/rules"
 	"github.com/BrandonWade/blackblade-batch/runner"
 	"github.com/BrandonWade/blackblade-batch/services"
 	"github.com/jmoiron/sqlx"
@@ -17,15 +25,81 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 )
 
+// Exit codes of a failed run, which the Kubernetes job uses to decide whether to retry it
+const (
+	exitCodeRetryable = 1
+	exitCodePermanent = 2
+)
+
+// maxTerminationMessageLength is the most of the termination message that Kubernetes reads
+const maxTerminationMessageLength = 4096
+
 var (
-	baseURL string
-	db      *sqlx.DB
-	client  *scryfall.Client
-	logger  *logrus.Logger
+	terminationMessagePath string
+	baseURL                string
+	dataDir                string
+	ingestMode             string
+	parallelism            int
+	batchSize              int
+	bulkTypes              []string
+	localizations          bool
+	cardRules              rules.Config
+	tombstoneMaxPercent    int
+	maxCountDropPercent    int
+	maxMissingFieldPercent int
+	shutdownGracePeriod    time.Duration
+	db                     *sqlx.DB
+	client                 *scryfall.Client
+	logger                 *logrus.Logger
 )
 
 func init() {
+	terminationMessagePath = os.Getenv("TERMINATION_MESSAGE_PATH")
+	if terminationMessagePath == "" {
+		terminationMessagePath = "/dev/termination-log"
+	}
+
 	baseURL = os.Getenv("BASE_SCRYFALL_URL")
+	dataDir = os.Getenv("BULK_DATA_DIR")
+	ingestMode = os.Getenv("CARD_INGEST_MODE")
+	if ingestMode == "" {
+		ingestMode = runner.IngestModeUpsert
+	} else if ingestMode != runner.IngestModeUpsert && ingestMode != runner.IngestModeStaging {
+		fatalf(exitCodePermanent, "invalid CARD_INGEST_MODE %q", ingestMode)
+	}
+
+	bulkTypes = runner.DefaultBulkTypes
+	if value := os.Getenv("BULK_DATA_TYPES"); value != "" {
+		bulkTypes = []string{}
+		for _, bulkType := range strings.Split(value, ",") {
+			// Accept the type as it appears in either the bulk data API path (default-cards) or response (default_cards)
+			bulkType = strings.ReplaceAll(strings.TrimSpace(bulkType), "-", "_")
+			if !runner.IsSupportedBulkType(bulkType) {
+				fatalf(exitCodePermanent, "invalid BULK_DATA_TYPES entry %q", bulkType)
+			}
+
+			bulkTypes = append(bulkTypes, bulkType)
+		}
+	}
+
+	localizations = os.Getenv("INGEST_LOCALIZATIONS") == "true"
+
+	var err error
+	if rulesFile := os.Getenv("CARD_RULES_FILE"); rulesFile != "" {
+		cardRules, err = rules.Load(rulesFile)
+	} else {
+		cardRules, err = rules.Default()
+	}
+	if err != nil {
+		fatalf(exitCodePermanent, "error loading card rules: %s", err.Error())
+	}
+
+	parallelism = getEnvInt("INGEST_PARALLELISM", 4)
+	batchSize = getEnvInt("INGEST_BATCH_SIZE", 100)
+	tombstoneMaxPercent = getEnvInt("TOMBSTONE_MAX_PERCENT", 5)
+	maxCountDropPercent = getEnvInt("SANITY_MAX_COUNT_DROP_PERCENT", 10)
+	maxMissingFieldPercent = getEnvInt("SANITY_MAX_MISSING_FIELD_PERCENT", 1)
+	shutdownGracePeriod = time.Duration(getEnvInt("SHUTDOWN_GRACE_PERIOD_SECONDS", 20)) * time.Second
 	dbUsername := os.Getenv("DB_USERNAME")
 	dbPassword := os.Getenv("DB_PASSWORD")
 	dbDatabase := os.Getenv("DB_DATABASE")
@@ -34,15 +108,14 @@ func init() {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", dbUsername, dbPassword, dbHost, dbPort, dbDatabase)
 
 	// Connect to MySQL
-	var err error
 	db, err = sqlx.Connect("mysql", dsn)
 	if err != nil {
-		log.Fatalf("error connecting to db: %s\n", err.Error())
+		fatalf(exitCodeRetryable, "error connecting to db: %s", err.Error())
 	}
 
 	client, err = scryfall.NewClient()
 	if err != nil {
-		log.Fatalf("error creating scryfall client: %s\n", err.Error())
+		fatalf(exitCodeRetryable, "error creating scryfall client: %s", err.Error())
 	}
 
 	db.SetConnMaxLifetime(time.Second)
@@ -53,11 +126,131 @@ func init() {
 func main() {
 	defer db.Close()
 
+	force := flag.Bool("force", false, "process bulk data files even if they have not changed since the last successful run")
+	skipSanityChecks := flag.Bool("skip-sanity-checks", false, "process bulk data files even if they look broken or truncated")
+	fullRebuild := flag.Bool("full-rebuild", false, "recalculate the derived data of every card and ruling instead of only those that changed")
+	flag.Parse()
+
 	scryfallClient := clients.NewScryfallClient(baseURL, logger, client)
 	cardRepository := repositories.NewCardRepository(logger, db)
+	batchRepository := repositories.NewBatchRepository(logger, db)
 	cardService := services.NewCardService(logger, scryfallClient, cardRepository)
-	batchRunner := runner.NewBatchRunner(logger, cardService)
+	batchService := services.NewBatchService(logger, batchRepository)
+	batchRunner := runner.NewBatchRunner(logger, cardService, batchService, runner.Config{
+		Force:                  *force,
+		DataDir:                dataDir,
+		IngestMode:             ingestMode,
+		Parallelism:            parallelism,
+		BatchSize:              batchSize,
+		BulkTypes:              bulkTypes,
+		Localizations:          localizations,
+		Rules:                  cardRules,
+		TombstoneMaxPercent:    tombstoneMaxPercent,
+		MaxCountDropPercent:    maxCountDropPercent,
+		MaxMissingFieldPercent: maxMissingFieldPercent,
+		SkipSanityChecks:       *skipSanityChecks,
+		FullRebuild:            *fullRebuild,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleShutdown(cancel)
+
+	var err error
+	switch flag.Arg(0) {
+	case "replay-failures":
+		// Re-attempt any cards or rulings that failed to process in previous runs
+		err = batchRunner.ReplayFailures(ctx)
+	case "restore-derived-tables":
+		// Roll the derived tables back to their previous generation
+		err = batchRunner.RestoreDerivedTables(ctx)
+	case "runs":
+		// List the most recent runs, or show the statistics of a single run
+		err = batchRunner.Runs(ctx, flag.Arg(1))
+	case "explain":
+		// Explain which inclusion rule included or excluded a card in the last run
+		err = batchRunner.Explain(ctx, strings.Join(flag.Args()[1:], " "))
+	default:
+		// Start the service to fetch cards from the Scryfall API
+		err = batchRunner.Run(ctx)
+	}
+
+	if errors.Is(err, context.Canceled) {
+		db.Close()
+		exit(exitCodeRetryable, "Batch cancelled: "+err.Error())
+	} else if err != nil {
+		code := exitCodePermanent
+		if runner.IsRetryable(err) {
+			code = exitCodeRetryable
+		}
+
+		db.Close()
+		exit(code, "Batch failed: "+err.Error())
+	}
+
+	writeTerminationMessage("Batch completed successfully")
+}
+
+// handleShutdown cancels the batch when the process is asked to terminate, so that the batch being written is rolled
+// back and the run is recorded as cancelled. If the batch hasn't stopped within the grace period, or a second signal
+// is received, the process exits immediately and the next run resumes from the last checkpoint.
+func handleShutdown(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	sig := <-signals
+	logger.Warnf("Received %s, shutting down...", sig)
+	cancel()
+
+	select {
+	case <-time.After(shutdownGracePeriod):
+		fatalf(exitCodeRetryable, "Batch did not shut down within %s", shutdownGracePeriod)
+	case sig = <-signals:
+		fatalf(exitCodeRetryable, "Received %s again, exiting without shutting down", sig)
+	}
+}
+
+// fatalf logs the provided message and exits with the provided code.
+func fatalf(code int, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Println(message)
+	exit(code, message)
+}
+
+// exit records the provided message as the termination message and exits with the provided code.
+func exit(code int, message string) {
+	writeTerminationMessage(message)
+	os.Exit(code)
+}
+
+// writeTerminationMessage writes a summary of the outcome of the batch to the file Kubernetes reports as the
+// reason the container terminated.
+func writeTerminationMessage(message string) {
+	if len(message) > maxTerminationMessageLength {
+		message = message[:maxTerminationMessageLength]
+	}
+
+	// Kubernetes creates the file, so nothing is written when running anywhere else
+	file, err := os.OpenFile(terminationMessagePath, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	file.WriteString(message)
+}
+
+// getEnvInt returns the positive integer value of the specified environment variable, or fallback if it is not set.
+func getEnvInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 1 {
+		fatalf(exitCodePermanent, "invalid %s %q", name, value)
+	}
 
-	// Start the service to fetch cards from the Scryfall API
-	batchRunner.Run()
+	return i
 }
@@ -0,0 +1,8 @@
+-- Tracks the last successfully processed version of each Scryfall bulk data file.
+CREATE TABLE IF NOT EXISTS batch_state (
+	bulk_type VARCHAR(64) NOT NULL,
+	bulk_id VARCHAR(36) NOT NULL,
+	updated_at VARCHAR(64) NOT NULL,
+	processed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	PRIMARY KEY (bulk_type)
+);
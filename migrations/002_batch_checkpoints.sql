@@ -0,0 +1,10 @@
+-- Tracks the progress of a bulk data file that is currently being processed so that a crashed run can resume.
+CREATE TABLE IF NOT EXISTS batch_checkpoints (
+	bulk_type VARCHAR(64) NOT NULL,
+	bulk_id VARCHAR(36) NOT NULL,
+	updated_at VARCHAR(64) NOT NULL,
+	file_path VARCHAR(255) NOT NULL,
+	item_index INT UNSIGNED NOT NULL DEFAULT 0,
+	saved_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	PRIMARY KEY (bulk_type)
+);
@@ -0,0 +1,14 @@
+-- Dead-letter records for bulk data items that could not be decoded or written to the database.
+CREATE TABLE IF NOT EXISTS batch_failures (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	bulk_type VARCHAR(64) NOT NULL,
+	item_id VARCHAR(36) NOT NULL DEFAULT '',
+	stage VARCHAR(32) NOT NULL,
+	error TEXT NOT NULL,
+	raw_json MEDIUMTEXT NOT NULL,
+	attempts INT UNSIGNED NOT NULL DEFAULT 1,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	resolved_at TIMESTAMP NULL DEFAULT NULL,
+	PRIMARY KEY (id),
+	KEY (resolved_at)
+);
@@ -0,0 +1,65 @@
+-- Staging tables used by the "staging" ingest mode. Rows are bulk loaded with LOAD DATA LOCAL INFILE, which
+-- requires local_infile to be enabled on the server, and are then merged into cards, card_faces and card_prices.
+CREATE TABLE IF NOT EXISTS cards_staging (
+	scryfall_id VARCHAR(36) NOT NULL,
+	oracle_id VARCHAR(36) NOT NULL DEFAULT '',
+	tcgplayer_id INT UNSIGNED NOT NULL DEFAULT 0,
+	card_back_id VARCHAR(36) NOT NULL DEFAULT '',
+	cmc DECIMAL(10, 2) NOT NULL DEFAULT 0,
+	name VARCHAR(255) NOT NULL DEFAULT '',
+	set_code VARCHAR(16) NOT NULL DEFAULT '',
+	set_name VARCHAR(255) NOT NULL DEFAULT '',
+	collector_number VARCHAR(16) NOT NULL DEFAULT '',
+	rarity VARCHAR(16) NOT NULL DEFAULT '',
+	layout VARCHAR(32) NOT NULL DEFAULT '',
+	border_color VARCHAR(16) NOT NULL DEFAULT '',
+	frame VARCHAR(16) NOT NULL DEFAULT '',
+	released_at VARCHAR(10) NOT NULL DEFAULT '',
+	has_foil TINYINT(1) NOT NULL DEFAULT 0,
+	has_nonfoil TINYINT(1) NOT NULL DEFAULT 0,
+	is_oversized TINYINT(1) NOT NULL DEFAULT 0,
+	is_reserved TINYINT(1) NOT NULL DEFAULT 0,
+	is_booster TINYINT(1) NOT NULL DEFAULT 0,
+	is_full_art TINYINT(1) NOT NULL DEFAULT 0,
+	is_textless TINYINT(1) NOT NULL DEFAULT 0,
+	is_reprint TINYINT(1) NOT NULL DEFAULT 0,
+	has_highres_image TINYINT(1) NOT NULL DEFAULT 0,
+	rulings_uri VARCHAR(255) NOT NULL DEFAULT '',
+	scryfall_uri VARCHAR(255) NOT NULL DEFAULT '',
+	usd VARCHAR(16) NOT NULL DEFAULT '',
+	usd_foil VARCHAR(16) NOT NULL DEFAULT '',
+	eur VARCHAR(16) NOT NULL DEFAULT '',
+	tix VARCHAR(16) NOT NULL DEFAULT '',
+	multiverse_ids JSON NOT NULL,
+	frame_effects JSON NOT NULL,
+	PRIMARY KEY (scryfall_id)
+);
+
+CREATE TABLE IF NOT EXISTS card_faces_staging (
+	scryfall_id VARCHAR(36) NOT NULL,
+	face_index TINYINT UNSIGNED NOT NULL,
+	is_white TINYINT(1) NOT NULL DEFAULT 0,
+	is_blue TINYINT(1) NOT NULL DEFAULT 0,
+	is_black TINYINT(1) NOT NULL DEFAULT 0,
+	is_red TINYINT(1) NOT NULL DEFAULT 0,
+	is_green TINYINT(1) NOT NULL DEFAULT 0,
+	artist VARCHAR(255) NOT NULL DEFAULT '',
+	flavor_text TEXT NOT NULL,
+	illustration_id VARCHAR(36) NOT NULL DEFAULT '',
+	image_small VARCHAR(255) NOT NULL DEFAULT '',
+	image_normal VARCHAR(255) NOT NULL DEFAULT '',
+	image_large VARCHAR(255) NOT NULL DEFAULT '',
+	image_png VARCHAR(255) NOT NULL DEFAULT '',
+	image_art_crop VARCHAR(255) NOT NULL DEFAULT '',
+	image_border_crop VARCHAR(255) NOT NULL DEFAULT '',
+	mana_cost VARCHAR(64) NOT NULL DEFAULT '',
+	name VARCHAR(255) NOT NULL DEFAULT '',
+	oracle_text TEXT NOT NULL,
+	power VARCHAR(8) NOT NULL DEFAULT '',
+	toughness VARCHAR(8) NOT NULL DEFAULT '',
+	loyalty VARCHAR(8) NOT NULL DEFAULT '',
+	type_line VARCHAR(255) NOT NULL DEFAULT '',
+	derived_type VARCHAR(32) NOT NULL DEFAULT '',
+	watermark VARCHAR(64) NOT NULL DEFAULT '',
+	PRIMARY KEY (scryfall_id, face_index)
+);
@@ -0,0 +1,71 @@
+-- Canonical version of each card from the oracle-cards bulk data file.
+CREATE TABLE IF NOT EXISTS oracle_cards (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	oracle_id VARCHAR(36) NOT NULL,
+	scryfall_id VARCHAR(36) NOT NULL,
+	name VARCHAR(255) NOT NULL DEFAULT '',
+	layout VARCHAR(32) NOT NULL DEFAULT '',
+	mana_cost VARCHAR(64) NOT NULL DEFAULT '',
+	cmc DECIMAL(10, 2) NOT NULL DEFAULT 0,
+	type_line VARCHAR(255) NOT NULL DEFAULT '',
+	oracle_text TEXT NOT NULL,
+	power VARCHAR(8) NOT NULL DEFAULT '',
+	toughness VARCHAR(8) NOT NULL DEFAULT '',
+	loyalty VARCHAR(8) NOT NULL DEFAULT '',
+	colors JSON NOT NULL,
+	color_identity JSON NOT NULL,
+	keywords JSON NOT NULL,
+	edhrec_rank INT UNSIGNED NOT NULL DEFAULT 0,
+	faces_json JSON NOT NULL,
+	card_json JSON NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY (oracle_id)
+);
+
+-- One row per illustration from the unique-artwork bulk data file.
+CREATE TABLE IF NOT EXISTS card_artwork (
+	illustration_id VARCHAR(36) NOT NULL,
+	oracle_id VARCHAR(36) NOT NULL,
+	scryfall_id VARCHAR(36) NOT NULL,
+	name VARCHAR(255) NOT NULL DEFAULT '',
+	set_code VARCHAR(16) NOT NULL DEFAULT '',
+	set_name VARCHAR(255) NOT NULL DEFAULT '',
+	artist VARCHAR(255) NOT NULL DEFAULT '',
+	image_art_crop VARCHAR(255) NOT NULL DEFAULT '',
+	image_normal VARCHAR(255) NOT NULL DEFAULT '',
+	released_at VARCHAR(10) NOT NULL DEFAULT '',
+	PRIMARY KEY (illustration_id),
+	KEY (oracle_id)
+);
+
+CREATE TABLE IF NOT EXISTS card_artwork_list (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	oracle_id VARCHAR(36) NOT NULL,
+	artwork_json JSON NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY (oracle_id)
+);
+
+-- Every printing of every card in every language from the all-cards bulk data file.
+CREATE TABLE IF NOT EXISTS card_prints (
+	scryfall_id VARCHAR(36) NOT NULL,
+	oracle_id VARCHAR(36) NOT NULL,
+	lang VARCHAR(8) NOT NULL,
+	name VARCHAR(255) NOT NULL DEFAULT '',
+	printed_name VARCHAR(255) NOT NULL DEFAULT '',
+	set_code VARCHAR(16) NOT NULL DEFAULT '',
+	set_name VARCHAR(255) NOT NULL DEFAULT '',
+	collector_number VARCHAR(16) NOT NULL DEFAULT '',
+	released_at VARCHAR(10) NOT NULL DEFAULT '',
+	image_normal VARCHAR(255) NOT NULL DEFAULT '',
+	PRIMARY KEY (scryfall_id),
+	KEY (oracle_id)
+);
+
+CREATE TABLE IF NOT EXISTS card_languages_list (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	oracle_id VARCHAR(36) NOT NULL,
+	languages_json JSON NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY (oracle_id)
+);
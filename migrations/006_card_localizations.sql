@@ -0,0 +1,27 @@
+-- Printed (possibly localized) text for each card face.
+ALTER TABLE card_faces
+	ADD COLUMN printed_name VARCHAR(255) NOT NULL DEFAULT '',
+	ADD COLUMN printed_type_line VARCHAR(255) NOT NULL DEFAULT '',
+	ADD COLUMN printed_text TEXT NULL;
+
+ALTER TABLE card_faces_staging
+	ADD COLUMN printed_name VARCHAR(255) NOT NULL DEFAULT '',
+	ADD COLUMN printed_type_line VARCHAR(255) NOT NULL DEFAULT '',
+	ADD COLUMN printed_text TEXT NULL;
+
+-- Non-English printings of each card from the all-cards bulk data file.
+CREATE TABLE IF NOT EXISTS card_localizations (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	oracle_id VARCHAR(36) NOT NULL,
+	set_code VARCHAR(16) NOT NULL,
+	collector_number VARCHAR(16) NOT NULL,
+	lang VARCHAR(8) NOT NULL,
+	scryfall_id VARCHAR(36) NOT NULL,
+	printed_name VARCHAR(255) NOT NULL DEFAULT '',
+	printed_type_line VARCHAR(255) NOT NULL DEFAULT '',
+	printed_text TEXT NULL,
+	faces_json JSON NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY (oracle_id, set_code, collector_number, lang),
+	KEY (printed_name)
+);
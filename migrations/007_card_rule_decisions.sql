@@ -0,0 +1,26 @@
+-- The inclusion rule that decided whether each card was included in the last run of each bulk data type.
+CREATE TABLE IF NOT EXISTS card_rule_decisions (
+	bulk_type VARCHAR(64) NOT NULL,
+	scryfall_id VARCHAR(36) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	included TINYINT(1) NOT NULL,
+	rule VARCHAR(255) NOT NULL,
+	decided_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	PRIMARY KEY (bulk_type, scryfall_id),
+	KEY (scryfall_id),
+	KEY (name)
+);
+
+-- A summary of how many cards each inclusion rule included or excluded in each run.
+CREATE TABLE IF NOT EXISTS card_rule_reports (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	bulk_type VARCHAR(64) NOT NULL,
+	bulk_id VARCHAR(36) NOT NULL,
+	updated_at VARCHAR(64) NOT NULL,
+	included INT UNSIGNED NOT NULL,
+	excluded INT UNSIGNED NOT NULL,
+	summary JSON NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY (bulk_type, created_at)
+);
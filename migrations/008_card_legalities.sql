@@ -0,0 +1,41 @@
+-- The legality of each card in each format, as last published by the batch.
+CREATE TABLE IF NOT EXISTS card_legalities (
+	oracle_id VARCHAR(36) NOT NULL,
+	format VARCHAR(32) NOT NULL,
+	status VARCHAR(32) NOT NULL,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	PRIMARY KEY (oracle_id, format)
+);
+
+-- The legalities observed while processing the default-cards bulk data file, diffed against card_legalities after each run.
+CREATE TABLE IF NOT EXISTS card_legalities_staging (
+	oracle_id VARCHAR(36) NOT NULL,
+	format VARCHAR(32) NOT NULL,
+	status VARCHAR(32) NOT NULL,
+	PRIMARY KEY (oracle_id, format)
+);
+
+-- History of every change to a card's legality in a format, e.g. bans, unbans and restrictions.
+CREATE TABLE IF NOT EXISTS legality_changes (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	oracle_id VARCHAR(36) NOT NULL,
+	format VARCHAR(32) NOT NULL,
+	old_status VARCHAR(32) NOT NULL,
+	new_status VARCHAR(32) NOT NULL,
+	changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY (oracle_id),
+	KEY (format, changed_at)
+);
+
+-- The legalities of each card in every format, as JSON.
+CREATE TABLE IF NOT EXISTS card_legalities_list (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	oracle_id VARCHAR(36) NOT NULL,
+	legalities_json JSON NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY (oracle_id)
+);
+
+ALTER TABLE cards
+	ADD COLUMN card_legalities_list_id INT UNSIGNED NULL;
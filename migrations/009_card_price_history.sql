@@ -0,0 +1,23 @@
+-- Prices for etched and EUR foil finishes.
+ALTER TABLE card_prices
+	ADD COLUMN usd_etched VARCHAR(16) NOT NULL DEFAULT '' AFTER usd_foil,
+	ADD COLUMN eur_foil VARCHAR(16) NOT NULL DEFAULT '' AFTER eur;
+
+ALTER TABLE cards_staging
+	ADD COLUMN usd_etched VARCHAR(16) NOT NULL DEFAULT '' AFTER usd_foil,
+	ADD COLUMN eur_foil VARCHAR(16) NOT NULL DEFAULT '' AFTER eur;
+
+-- Append-only snapshot of every card's prices, taken once per run of the default-cards bulk data file and keyed
+-- by the time Scryfall updated the file. card_prices continues to hold only the latest prices.
+CREATE TABLE IF NOT EXISTS card_price_history (
+	scryfall_id VARCHAR(36) NOT NULL,
+	snapshot_at DATETIME NOT NULL,
+	usd VARCHAR(16) NOT NULL DEFAULT '',
+	usd_foil VARCHAR(16) NOT NULL DEFAULT '',
+	usd_etched VARCHAR(16) NOT NULL DEFAULT '',
+	eur VARCHAR(16) NOT NULL DEFAULT '',
+	eur_foil VARCHAR(16) NOT NULL DEFAULT '',
+	tix VARCHAR(16) NOT NULL DEFAULT '',
+	PRIMARY KEY (scryfall_id, snapshot_at),
+	KEY (snapshot_at)
+);
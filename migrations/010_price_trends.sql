@@ -0,0 +1,54 @@
+-- The change in price of each card over each trend window, recalculated after every price history snapshot.
+-- Prices are in USD, falling back to the foil price for cards without a non-foil printing.
+CREATE TABLE IF NOT EXISTS price_trends (
+	scryfall_id VARCHAR(36) NOT NULL,
+	window_days SMALLINT UNSIGNED NOT NULL,
+	oracle_id VARCHAR(36) NOT NULL,
+	set_code VARCHAR(16) NOT NULL,
+	price_start DECIMAL(10, 2) NOT NULL,
+	price_end DECIMAL(10, 2) NOT NULL,
+	delta DECIMAL(10, 2) NOT NULL,
+	delta_pct DECIMAL(12, 2) NOT NULL,
+	is_suspicious TINYINT(1) NOT NULL DEFAULT 0,
+	PRIMARY KEY (scryfall_id, window_days),
+	KEY (window_days, set_code)
+);
+
+-- The change in price of the cheapest printing of each card over each trend window.
+CREATE TABLE IF NOT EXISTS oracle_price_trends (
+	oracle_id VARCHAR(36) NOT NULL,
+	window_days SMALLINT UNSIGNED NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	price_start DECIMAL(10, 2) NOT NULL,
+	price_end DECIMAL(10, 2) NOT NULL,
+	delta DECIMAL(10, 2) NOT NULL,
+	delta_pct DECIMAL(12, 2) NOT NULL,
+	is_suspicious TINYINT(1) NOT NULL DEFAULT 0,
+	PRIMARY KEY (oracle_id, window_days)
+);
+
+-- The biggest gainers and losers in each set (by printing) and format (by card) over each trend window.
+-- Suspicious price changes are excluded.
+CREATE TABLE IF NOT EXISTS price_movers (
+	window_days SMALLINT UNSIGNED NOT NULL,
+	scope VARCHAR(16) NOT NULL,
+	scope_code VARCHAR(32) NOT NULL,
+	direction VARCHAR(16) NOT NULL,
+	position TINYINT UNSIGNED NOT NULL,
+	item_id VARCHAR(36) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	price_start DECIMAL(10, 2) NOT NULL,
+	price_end DECIMAL(10, 2) NOT NULL,
+	delta DECIMAL(10, 2) NOT NULL,
+	delta_pct DECIMAL(12, 2) NOT NULL,
+	PRIMARY KEY (window_days, scope, scope_code, direction, position)
+);
+
+-- The price movers for each window, set and format, as JSON.
+CREATE TABLE IF NOT EXISTS price_movers_list (
+	window_days SMALLINT UNSIGNED NOT NULL,
+	scope VARCHAR(16) NOT NULL,
+	scope_code VARCHAR(32) NOT NULL,
+	movers_json JSON NOT NULL,
+	PRIMARY KEY (window_days, scope, scope_code)
+);
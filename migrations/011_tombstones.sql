@@ -0,0 +1,38 @@
+-- Cards and rulings that no longer appear in the Scryfall bulk data are marked as removed instead of being deleted.
+-- The reason is "missing" for items no longer in the bulk data, or "excluded" for items the inclusion rules exclude.
+ALTER TABLE cards
+	ADD COLUMN removed_at TIMESTAMP NULL DEFAULT NULL,
+	ADD COLUMN removed_reason VARCHAR(16) NULL DEFAULT NULL,
+	ADD KEY (removed_at);
+
+ALTER TABLE card_rulings
+	ADD COLUMN removed_at TIMESTAMP NULL DEFAULT NULL,
+	ADD COLUMN removed_reason VARCHAR(16) NULL DEFAULT NULL,
+	ADD KEY (removed_at);
+
+-- Oracle cards, illustrations and printings from the oracle-cards, unique-artwork and all-cards bulk data files are
+-- marked as removed in the same way.
+ALTER TABLE oracle_cards
+	ADD COLUMN removed_at TIMESTAMP NULL DEFAULT NULL,
+	ADD COLUMN removed_reason VARCHAR(16) NULL DEFAULT NULL,
+	ADD KEY (removed_at);
+
+ALTER TABLE card_artwork
+	ADD COLUMN removed_at TIMESTAMP NULL DEFAULT NULL,
+	ADD COLUMN removed_reason VARCHAR(16) NULL DEFAULT NULL,
+	ADD KEY (removed_at);
+
+ALTER TABLE card_prints
+	ADD COLUMN removed_at TIMESTAMP NULL DEFAULT NULL,
+	ADD COLUMN removed_reason VARCHAR(16) NULL DEFAULT NULL,
+	ADD KEY (removed_at);
+
+-- The key of every item seen in the current run of each bulk data type: the scryfall ID for cards, the oracle ID for
+-- oracle cards, or "<oracle ID>:<comment hash>" for rulings. Items missing from this table are marked as removed at the end of the run,
+-- as are items seen but excluded by the inclusion rules.
+CREATE TABLE IF NOT EXISTS batch_seen_items (
+	bulk_type VARCHAR(64) NOT NULL,
+	item_key VARCHAR(80) NOT NULL,
+	excluded TINYINT(1) NOT NULL DEFAULT 0,
+	PRIMARY KEY (bulk_type, item_key)
+);
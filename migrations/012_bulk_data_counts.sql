@@ -0,0 +1,9 @@
+-- The number of items in each bulk data file, and how many of them were included, so that each run can be
+-- sanity checked against the previous one.
+ALTER TABLE batch_state
+	ADD COLUMN item_count INT UNSIGNED NOT NULL DEFAULT 0,
+	ADD COLUMN included_count INT UNSIGNED NOT NULL DEFAULT 0;
+
+ALTER TABLE batch_checkpoints
+	ADD COLUMN item_count INT UNSIGNED NOT NULL DEFAULT 0,
+	ADD COLUMN included_count INT UNSIGNED NOT NULL DEFAULT 0;
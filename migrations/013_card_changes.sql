@@ -0,0 +1,46 @@
+-- The time each card, face, price, ruling, localization and oracle card row was last inserted or modified. MySQL only
+-- bumps an ON UPDATE column when another column actually changes, so rewriting a row with the same values leaves it
+-- untouched.
+ALTER TABLE cards
+	ADD COLUMN changed_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+	ADD KEY (changed_at);
+
+ALTER TABLE card_faces
+	ADD COLUMN changed_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+	ADD KEY (changed_at);
+
+ALTER TABLE card_prices
+	ADD COLUMN changed_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+	ADD KEY (changed_at);
+
+ALTER TABLE card_rulings
+	ADD COLUMN changed_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+	ADD KEY (changed_at);
+
+ALTER TABLE card_localizations
+	ADD COLUMN changed_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+	ADD KEY (changed_at);
+
+ALTER TABLE oracle_cards
+	ADD COLUMN changed_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+	ADD KEY (changed_at);
+
+-- The cards and oracle IDs whose derived data is out of date. Rows are added as cards and rulings are written, and
+-- cleared once the derived data has been recalculated for them.
+CREATE TABLE IF NOT EXISTS changed_cards (
+	card_id INT UNSIGNED NOT NULL,
+	oracle_id VARCHAR(36) NOT NULL,
+	PRIMARY KEY (card_id),
+	KEY (oracle_id)
+);
+
+CREATE TABLE IF NOT EXISTS changed_rulings (
+	oracle_id VARCHAR(36) NOT NULL,
+	PRIMARY KEY (oracle_id)
+);
+
+-- The oracle cards whose card_json is out of date
+CREATE TABLE IF NOT EXISTS changed_oracle_cards (
+	oracle_id VARCHAR(36) NOT NULL,
+	PRIMARY KEY (oracle_id)
+);
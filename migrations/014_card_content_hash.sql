@@ -0,0 +1,7 @@
+-- A SHA-256 hash of the content of each card, excluding prices, so that cards that haven't changed since the
+-- last run can be skipped. Cards written before the hash existed have an empty hash and are rewritten once.
+ALTER TABLE cards
+	ADD COLUMN content_hash CHAR(64) NOT NULL DEFAULT '';
+
+ALTER TABLE cards_staging
+	ADD COLUMN content_hash CHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,30 @@
+-- History of every run of the batch.
+CREATE TABLE IF NOT EXISTS batch_runs (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	finished_at TIMESTAMP NULL DEFAULT NULL,
+	status VARCHAR(16) NOT NULL,
+	error TEXT NULL,
+	PRIMARY KEY (id),
+	KEY (started_at)
+);
+
+-- Statistics for each bulk data file processed by a run. stage_durations is a JSON array of the milliseconds
+-- spent in each stage, in the order the stages ran.
+CREATE TABLE IF NOT EXISTS batch_run_bulk_data (
+	run_id INT UNSIGNED NOT NULL,
+	bulk_type VARCHAR(64) NOT NULL,
+	bulk_id VARCHAR(36) NOT NULL DEFAULT '',
+	updated_at VARCHAR(64) NOT NULL DEFAULT '',
+	status VARCHAR(16) NOT NULL,
+	decoded_count INT UNSIGNED NOT NULL DEFAULT 0,
+	filtered_count INT UNSIGNED NOT NULL DEFAULT 0,
+	written_count INT UNSIGNED NOT NULL DEFAULT 0,
+	inserted_count INT UNSIGNED NOT NULL DEFAULT 0,
+	updated_count INT UNSIGNED NOT NULL DEFAULT 0,
+	unchanged_count INT UNSIGNED NOT NULL DEFAULT 0,
+	failed_count INT UNSIGNED NOT NULL DEFAULT 0,
+	stage_durations JSON NULL,
+	error TEXT NULL,
+	PRIMARY KEY (run_id, bulk_type)
+);
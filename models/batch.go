@@ -0,0 +1,66 @@
+package models
+
+// BulkDataState represents the last successfully processed version of a bulk data file.
+type BulkDataState struct {
+	BulkType      string `db:"bulk_type"`
+	BulkID        string `db:"bulk_id"`
+	UpdatedAt     string `db:"updated_at"`
+	ItemCount     int    `db:"item_count"`
+	IncludedCount int    `db:"included_count"`
+}
+
+// BulkDataCheckpoint represents the progress made processing a bulk data file.
+type BulkDataCheckpoint struct {
+	BulkType  string `db:"bulk_type"`
+	BulkID    string `db:"bulk_id"`
+	UpdatedAt string `db:"updated_at"`
+	FilePath  string `db:"file_path"`
+	ItemIndex int    `db:"item_index"`
+	// ItemCount and IncludedCount are the number of items in the file and how many of them are included,
+	// counted when the file is sanity checked before processing starts
+	ItemCount     int `db:"item_count"`
+	IncludedCount int `db:"included_count"`
+}
+
+// Stages of processing a bulk data item that can fail.
+const (
+	FailureStageDecode = "decode"
+	FailureStageUpsert = "upsert"
+)
+
+// BatchFailure represents a bulk data item that could not be processed.
+type BatchFailure struct {
+	ID       int64  `db:"id"`
+	BulkType string `db:"bulk_type"`
+	ItemID   string `db:"item_id"` // The scryfall ID for cards, or the oracle ID for rulings
+	Stage    string `db:"stage"`
+	Error    string `db:"error"`
+	RawJSON  string `db:"raw_json"`
+}
+
+// RuleDecision represents the inclusion rule that decided whether a card was included in a run.
+type RuleDecision struct {
+	BulkType   string `db:"bulk_type"`
+	ScryfallID string `db:"scryfall_id"`
+	Name       string `db:"name"`
+	Included   bool   `db:"included"`
+	Rule       string `db:"rule"`
+	DecidedAt  string `db:"decided_at"`
+}
+
+// RuleCount represents the number of cards a single inclusion rule included or excluded.
+type RuleCount struct {
+	Rule     string `db:"rule" json:"rule"`
+	Included bool   `db:"included" json:"included"`
+	Count    int    `db:"count" json:"count"`
+}
+
+// RuleReport represents a summary of the inclusion rule decisions made in a run.
+type RuleReport struct {
+	BulkType  string      `json:"bulk_type"`
+	BulkID    string      `json:"bulk_id"`
+	UpdatedAt string      `json:"updated_at"`
+	Included  int         `json:"included"`
+	Excluded  int         `json:"excluded"`
+	Rules     []RuleCount `json:"rules"`
+}
@@ -0,0 +1,17 @@
+package models
+
+// RemovedCardRows represents the child rows of cards that were removed because they no longer appear in the bulk data.
+type RemovedCardRows struct {
+	MultiverseIDs int64
+	FrameEffects  int64
+	Faces         int64
+}
+
+// CardWriteStats represents the outcome of writing cards to the database. Cards whose content is unchanged since
+// they were last written only have their prices updated.
+type CardWriteStats struct {
+	Inserted  int64
+	Changed   int64
+	Unchanged int64
+	Removed   RemovedCardRows
+}
@@ -0,0 +1,46 @@
+package models
+
+// Statuses of a batch run, and of each bulk data file processed by it
+const (
+	RunStatusRunning   = "running"
+	RunStatusSucceeded = "succeeded"
+	RunStatusFailed    = "failed"
+	RunStatusSkipped   = "skipped"
+	RunStatusCancelled = "cancelled"
+)
+
+// BatchRun represents a single run of the batch
+type BatchRun struct {
+	ID         int64  `db:"id"`
+	StartedAt  string `db:"started_at"`
+	FinishedAt string `db:"finished_at"`
+	Status     string `db:"status"`
+	Error      string `db:"error"`
+}
+
+// BatchRunBulkData represents the processing of a single bulk data file during a batch run
+type BatchRunBulkData struct {
+	RunID     int64  `db:"run_id"`
+	BulkType  string `db:"bulk_type"`
+	BulkID    string `db:"bulk_id"`
+	UpdatedAt string `db:"updated_at"`
+	Status    string `db:"status"`
+	Decoded   int64  `db:"decoded_count"`
+	Filtered  int64  `db:"filtered_count"`
+	Written   int64  `db:"written_count"`
+	Inserted  int64  `db:"inserted_count"`
+	Updated   int64  `db:"updated_count"`
+	Unchanged int64  `db:"unchanged_count"`
+	Failed    int64  `db:"failed_count"`
+	Error     string `db:"error"`
+
+	// StageDurations is the JSON encoding of Stages, as stored in the database
+	StageDurations string          `db:"stage_durations"`
+	Stages         []StageDuration `db:"-"`
+}
+
+// StageDuration represents the time spent in one stage of processing a bulk data file
+type StageDuration struct {
+	Stage    string `json:"stage"`
+	Duration int64  `json:"duration_ms"`
+}
@@ -9,6 +9,7 @@ type ScryfallBulkData struct {
 	URI             string `json:"uri"`
 	Name            string `json:"name"`
 	Description     string `json:"description"`
+	Size            int64  `json:"size"`
 	CompressedSize  int64  `json:"compressed_size"`
 	DownloadURI     string `json:"download_uri"`
 	ContentType     string `json:"content_type"`
@@ -128,11 +129,12 @@ type ScryfallLegalities struct {
 
 // ScryfallPrices represents a scryfall card's prices
 type ScryfallPrices struct {
-	USD     string `json:"usd"`
-	USDFoil string `json:"usd_foil"`
-	EUR     string `json:"eur"`
-	EURFoil string `json:"eur_foil"`
-	Tix     string `json:"tix"`
+	USD       string `json:"usd"`
+	USDFoil   string `json:"usd_foil"`
+	USDEtched string `json:"usd_etched"`
+	EUR       string `json:"eur"`
+	EURFoil   string `json:"eur_foil"`
+	Tix       string `json:"tix"`
 }
 
 // ScryfallRelatedURIs represents a scryfall card's related URIs
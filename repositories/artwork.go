@@ -0,0 +1,93 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+)
+
+var cardArtworkColumns = []string{
+	"illustration_id",
+	"oracle_id",
+	"scryfall_id",
+	"name",
+	"set_code",
+	"set_name",
+	"artist",
+	"image_art_crop",
+	"image_normal",
+	"released_at",
+}
+
+// UpsertCardArtwork upserts a row for each distinct illustration on the provided cards into the database
+func (c *cardRepository) UpsertCardArtwork(ctx context.Context, cards []models.ScryfallCard) error {
+	seen := map[string]bool{}
+	rows := [][]interface{}{}
+	for _, card := range cards {
+		c.setLayout(&card)
+
+		for _, cardFace := range c.getCardFaces(card) {
+			// Cards with a single image for every face only have an illustration on the card itself
+			illustrationID := cardFace.IllustrationID
+			if illustrationID == "" {
+				illustrationID = card.IllustrationID
+			}
+
+			if illustrationID == "" || seen[illustrationID] {
+				continue
+			}
+			seen[illustrationID] = true
+
+			rows = append(rows, []interface{}{
+				illustrationID,
+				card.OracleID,
+				card.ID,
+				cardFace.Name,
+				card.Set,
+				card.SetName,
+				cardFace.Artist,
+				cardFace.ImageURIs.ArtCrop,
+				cardFace.ImageURIs.Normal,
+				card.ReleasedAt,
+			})
+		}
+	}
+
+	return retryOnLockContention(func() error {
+		return execBulkInsertTx(ctx, c.db, "INSERT INTO card_artwork", cardArtworkColumns, onDuplicateKeyUpdate(cardArtworkColumns[1:]), rows)
+	})
+}
+
+// GenerateCardArtworkJSON aggregates the artwork for each distinct card in the database and saves the result.
+func (c *cardRepository) GenerateCardArtworkJSON(ctx context.Context) error {
+	return c.rebuildTable(ctx, "card_artwork_list", "oracle_id", `INSERT INTO %s (oracle_id, artwork_json)
+		SELECT
+		a.oracle_id,
+		JSON_ARRAYAGG(JSON_OBJECT(
+			'illustration_id', a.illustration_id,
+			'scryfall_id', a.scryfall_id,
+			'name', a.name,
+			'set_code', a.set_code,
+			'set_name', a.set_name,
+			'artist', a.artist,
+			'image_art_crop', a.image_art_crop,
+			'image', a.image_normal
+		)) artwork
+		FROM (
+			SELECT
+			w.illustration_id,
+			w.oracle_id,
+			w.scryfall_id,
+			w.name,
+			w.set_code,
+			w.set_name,
+			w.artist,
+			w.image_art_crop,
+			w.image_normal
+			FROM card_artwork w
+			WHERE w.removed_at IS NULL
+			ORDER BY w.oracle_id, w.released_at DESC
+		) a
+		GROUP BY a.oracle_id
+	`)
+}
@@ -0,0 +1,282 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// BatchRepository interface for working with a batchRepository
+type BatchRepository interface {
+	GetBulkDataState(ctx context.Context, bulkType string) (models.BulkDataState, error)
+	SaveBulkDataState(ctx context.Context, state models.BulkDataState) error
+	GetCheckpoint(ctx context.Context, bulkType string) (models.BulkDataCheckpoint, error)
+	SaveCheckpoint(ctx context.Context, checkpoint models.BulkDataCheckpoint) error
+	DeleteCheckpoint(ctx context.Context, bulkType string) error
+	InsertFailures(ctx context.Context, failures []models.BatchFailure) error
+	GetUnresolvedFailures(ctx context.Context) ([]models.BatchFailure, error)
+	ResolveFailure(ctx context.Context, id int64) error
+	UpdateFailure(ctx context.Context, id int64, failureErr string) error
+	DeleteRuleDecisions(ctx context.Context, bulkType string) error
+	UpsertRuleDecisions(ctx context.Context, decisions []models.RuleDecision) error
+	GetRuleCounts(ctx context.Context, bulkType string) ([]models.RuleCount, error)
+	InsertRuleReport(ctx context.Context, report models.RuleReport, summary string) error
+	GetRuleDecisions(ctx context.Context, query string) ([]models.RuleDecision, error)
+	DeleteSeenItems(ctx context.Context, bulkType string) error
+	InsertSeenItems(ctx context.Context, bulkType string, keys []string, excluded bool) error
+	InsertRun(ctx context.Context) (int64, error)
+	FinishRun(ctx context.Context, id int64, status, runErr string) error
+	InsertRunBulkData(ctx context.Context, data models.BatchRunBulkData) error
+	GetRuns(ctx context.Context, limit int) ([]models.BatchRun, error)
+	GetRun(ctx context.Context, id int64) (models.BatchRun, error)
+	GetRunBulkData(ctx context.Context, runID int64) ([]models.BatchRunBulkData, error)
+}
+
+type batchRepository struct {
+	logger *logrus.Logger
+	db     *sqlx.DB
+}
+
+// NewBatchRepository create a new BatchRepository instance
+func NewBatchRepository(logger *logrus.Logger, db *sqlx.DB) BatchRepository {
+	return &batchRepository{
+		logger,
+		db,
+	}
+}
+
+// GetBulkDataState returns the last successfully processed version of the specified bulk data type.
+func (b *batchRepository) GetBulkDataState(ctx context.Context, bulkType string) (models.BulkDataState, error) {
+	state := models.BulkDataState{}
+	err := b.db.GetContext(ctx, &state, `SELECT
+		s.bulk_type,
+		s.bulk_id,
+		s.updated_at,
+		s.item_count,
+		s.included_count
+		FROM batch_state s
+		WHERE s.bulk_type = ?
+	`,
+		bulkType,
+	)
+	if err == sql.ErrNoRows {
+		return models.BulkDataState{}, nil
+	} else if err != nil {
+		return models.BulkDataState{}, err
+	}
+
+	return state, nil
+}
+
+// SaveBulkDataState records the provided version of a bulk data file as successfully processed.
+func (b *batchRepository) SaveBulkDataState(ctx context.Context, state models.BulkDataState) error {
+	_, err := b.db.ExecContext(ctx, `INSERT INTO batch_state (
+		bulk_type,
+		bulk_id,
+		updated_at,
+		item_count,
+		included_count
+	) VALUES (
+		?,
+		?,
+		?,
+		?,
+		?
+	) ON DUPLICATE KEY UPDATE
+		bulk_id = ?,
+		updated_at = ?,
+		item_count = ?,
+		included_count = ?
+	`,
+		state.BulkType,
+		state.BulkID,
+		state.UpdatedAt,
+		state.ItemCount,
+		state.IncludedCount,
+		state.BulkID,
+		state.UpdatedAt,
+		state.ItemCount,
+		state.IncludedCount,
+	)
+
+	return err
+}
+
+// GetCheckpoint returns the saved progress for the specified bulk data type.
+func (b *batchRepository) GetCheckpoint(ctx context.Context, bulkType string) (models.BulkDataCheckpoint, error) {
+	checkpoint := models.BulkDataCheckpoint{}
+	err := b.db.GetContext(ctx, &checkpoint, `SELECT
+		c.bulk_type,
+		c.bulk_id,
+		c.updated_at,
+		c.file_path,
+		c.item_index,
+		c.item_count,
+		c.included_count
+		FROM batch_checkpoints c
+		WHERE c.bulk_type = ?
+	`,
+		bulkType,
+	)
+	if err == sql.ErrNoRows {
+		return models.BulkDataCheckpoint{}, nil
+	} else if err != nil {
+		return models.BulkDataCheckpoint{}, err
+	}
+
+	return checkpoint, nil
+}
+
+// SaveCheckpoint saves the progress made processing a bulk data file.
+func (b *batchRepository) SaveCheckpoint(ctx context.Context, checkpoint models.BulkDataCheckpoint) error {
+	_, err := b.db.ExecContext(ctx, `INSERT INTO batch_checkpoints (
+		bulk_type,
+		bulk_id,
+		updated_at,
+		file_path,
+		item_index,
+		item_count,
+		included_count
+	) VALUES (
+		?,
+		?,
+		?,
+		?,
+		?,
+		?,
+		?
+	) ON DUPLICATE KEY UPDATE
+		bulk_id = ?,
+		updated_at = ?,
+		file_path = ?,
+		item_index = ?,
+		item_count = ?,
+		included_count = ?
+	`,
+		checkpoint.BulkType,
+		checkpoint.BulkID,
+		checkpoint.UpdatedAt,
+		checkpoint.FilePath,
+		checkpoint.ItemIndex,
+		checkpoint.ItemCount,
+		checkpoint.IncludedCount,
+		checkpoint.BulkID,
+		checkpoint.UpdatedAt,
+		checkpoint.FilePath,
+		checkpoint.ItemIndex,
+		checkpoint.ItemCount,
+		checkpoint.IncludedCount,
+	)
+
+	return err
+}
+
+// DeleteCheckpoint removes the saved progress for the specified bulk data type.
+func (b *batchRepository) DeleteCheckpoint(ctx context.Context, bulkType string) error {
+	_, err := b.db.ExecContext(ctx, `DELETE FROM batch_checkpoints
+		WHERE bulk_type = ?
+	`,
+		bulkType,
+	)
+
+	return err
+}
+
+// InsertFailures records the provided failed bulk data items.
+func (b *batchRepository) InsertFailures(ctx context.Context, failures []models.BatchFailure) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, failure := range failures {
+		_, err = tx.Exec(`INSERT INTO batch_failures (
+			bulk_type,
+			item_id,
+			stage,
+			error,
+			raw_json
+		) VALUES (
+			?,
+			?,
+			?,
+			?,
+			?
+		)
+		`,
+			failure.BulkType,
+			failure.ItemID,
+			failure.Stage,
+			failure.Error,
+			failure.RawJSON,
+		)
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// GetUnresolvedFailures returns every failed bulk data item that has not yet been successfully replayed.
+func (b *batchRepository) GetUnresolvedFailures(ctx context.Context) ([]models.BatchFailure, error) {
+	failures := []models.BatchFailure{}
+	err := b.db.SelectContext(ctx, &failures, `SELECT
+		f.id,
+		f.bulk_type,
+		f.item_id,
+		f.stage,
+		f.error,
+		f.raw_json
+		FROM batch_failures f
+		WHERE f.resolved_at IS NULL
+		ORDER BY f.id
+	`)
+	if err != nil {
+		return []models.BatchFailure{}, err
+	}
+
+	return failures, nil
+}
+
+// ResolveFailure marks a failed bulk data item as successfully replayed.
+func (b *batchRepository) ResolveFailure(ctx context.Context, id int64) error {
+	_, err := b.db.ExecContext(ctx, `UPDATE batch_failures
+		SET resolved_at = NOW()
+		WHERE id = ?
+	`,
+		id,
+	)
+
+	return err
+}
+
+// UpdateFailure records another unsuccessful attempt to replay a failed bulk data item.
+func (b *batchRepository) UpdateFailure(ctx context.Context, id int64, failureErr string) error {
+	_, err := b.db.ExecContext(ctx, `UPDATE batch_failures
+		SET error = ?,
+		attempts = attempts + 1
+		WHERE id = ?
+	`,
+		failureErr,
+		id,
+	)
+
+	return err
+}
@@ -0,0 +1,99 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MySQL limits a prepared statement to 65535 placeholders
+const maxPlaceholders = 65535
+
+// execBulkInsert inserts the provided rows using as few multi-row INSERT statements as possible. The statement
+// is built as "<insert> (<columns>) VALUES (?, ...), (?, ...) <suffix>", where suffix is typically an
+// ON DUPLICATE KEY UPDATE clause.
+func execBulkInsert(tx *sqlx.Tx, insert string, columns []string, suffix string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	rowsPerStatement := maxPlaceholders / len(columns)
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+
+	for start := 0; start < len(rows); start += rowsPerStatement {
+		end := start + rowsPerStatement
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		values := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*len(columns))
+		for _, row := range rows[start:end] {
+			values = append(values, placeholders)
+			args = append(args, row...)
+		}
+
+		query := insert + " (" + strings.Join(columns, ", ") + ") VALUES " + strings.Join(values, ", ") + " " + suffix
+		_, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// onDuplicateKeyUpdate builds an ON DUPLICATE KEY UPDATE clause that sets each of the provided columns
+// to the value that was being inserted.
+func onDuplicateKeyUpdate(columns []string) string {
+	updates := make([]string, 0, len(columns))
+	for _, column := range columns {
+		updates = append(updates, column+" = VALUES("+column+")")
+	}
+
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+}
+
+// execBulkInsertTx runs execBulkInsert in its own transaction.
+func execBulkInsertTx(ctx context.Context, db *sqlx.DB, insert string, columns []string, suffix string, rows [][]interface{}) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = execBulkInsert(tx, insert, columns, suffix, rows)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+
+		return &StatementError{insert, err}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// jsonArray marshals the provided slice as a JSON array, using an empty array rather than null for nil slices.
+func jsonArray[T any](items []T) (string, error) {
+	if items == nil {
+		items = []T{}
+	}
+
+	b, err := json.Marshal(items)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
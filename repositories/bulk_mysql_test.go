@@ -0,0 +1,69 @@
+//go:build mysql
+
+package repositories
+
+import (
+	"os"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// newMySQLBenchmarkDB connects to the database in BENCHMARK_DB_DSN, skipping the benchmark if it is not set. The
+// database must have the blackblade schema and the batch migrations applied.
+func newMySQLBenchmarkDB(b *testing.B) *sqlx.DB {
+	dsn := os.Getenv("BENCHMARK_DB_DSN")
+	if dsn == "" {
+		b.Skip("BENCHMARK_DB_DSN is not set")
+	}
+
+	db, err := sqlx.Connect("mysql", dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return db
+}
+
+// BenchmarkUpsertCardRowsMySQL compares writing a batch of cards with one statement per card against a single
+// multi-row statement on a real database. Each batch is written in a transaction that is rolled back, so every
+// iteration inserts the same new cards and the database is left unchanged.
+func BenchmarkUpsertCardRowsMySQL(b *testing.B) {
+	db := newMySQLBenchmarkDB(b)
+	defer db.Close()
+
+	cards := benchmarkCards(benchmarkBatchSize)
+
+	writers := []struct {
+		name  string
+		write func(tx *sqlx.Tx) error
+	}{
+		{"per-row", func(tx *sqlx.Tx) error { return upsertCardRowsPerRow(tx, cards) }},
+		{"multi-row", func(tx *sqlx.Tx) error { return upsertCardRows(tx, cards) }},
+	}
+
+	for _, writer := range writers {
+		b.Run(writer.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tx, err := db.Beginx()
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				err = writer.write(tx)
+				if err != nil {
+					tx.Rollback()
+					b.Fatal(err)
+				}
+
+				b.StopTimer()
+				err = tx.Rollback()
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package repositories
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+// benchmarkBatchSize matches the default INGEST_BATCH_SIZE
+const benchmarkBatchSize = 100
+
+// benchmarkCards returns a batch of distinct cards to write.
+func benchmarkCards(n int) []models.ScryfallCard {
+	cards := make([]models.ScryfallCard, 0, n)
+	for i := 0; i < n; i++ {
+		cards = append(cards, models.ScryfallCard{
+			ID:              fmt.Sprintf("00000000-0000-0000-0000-%012d", i),
+			OracleID:        fmt.Sprintf("10000000-0000-0000-0000-%012d", i),
+			Name:            fmt.Sprintf("Card %d", i),
+			Set:             "tst",
+			SetName:         "Test Set",
+			CollectorNumber: fmt.Sprint(i),
+			Rarity:          "common",
+			TypeLine:        "Creature — Goblin",
+		})
+	}
+
+	return cards
+}
+
+// newBenchmarkDB returns a mock database expecting a transaction with the provided number of statements, each taking
+// roundTrip to execute.
+func newBenchmarkDB(b *testing.B, statements int, roundTrip time.Duration) *sqlx.DB {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherFunc(func(string, string) error {
+		return nil
+	})))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	for s := 0; s < statements; s++ {
+		mock.ExpectExec("INSERT INTO cards").WillDelayFor(roundTrip).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+
+	return sqlx.NewDb(db, "mysql")
+}
+
+// benchmarkWrite runs the provided write in a transaction against a fresh mock database for each iteration.
+func benchmarkWrite(b *testing.B, statements int, roundTrip time.Duration, write func(tx *sqlx.Tx) error) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db := newBenchmarkDB(b, statements, roundTrip)
+		b.StartTimer()
+
+		tx, err := db.Beginx()
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		err = write(tx)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		db.Close()
+		b.StartTimer()
+	}
+}
+
+// upsertCardRowsPerRow writes the cards with one INSERT per card, as cards were written before multi-row statements.
+func upsertCardRowsPerRow(tx *sqlx.Tx, cards []models.ScryfallCard) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(cardColumns)), ", ")
+	query := "INSERT INTO cards (" + strings.Join(cardColumns, ", ") + ") VALUES (" + placeholders + ") " + onDuplicateKeyUpdate(cardColumns)
+
+	for _, card := range cards {
+		_, err := tx.Exec(query, cardValues(card)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BenchmarkUpsertCardRows compares writing a batch of cards with one statement per card against a single multi-row
+// statement. The statements are executed against sqlmock, optionally delayed by a simulated database round trip,
+// so the results show the cost of building the statements and of the round trips they need. The delay is only a
+// model of a database: it is the same for every statement, however many rows it writes, and ignores the time MySQL
+// spends executing it. BenchmarkUpsertCardRowsMySQL in bulk_mysql_test.go measures both on a real database.
+func BenchmarkUpsertCardRows(b *testing.B) {
+	cards := benchmarkCards(benchmarkBatchSize)
+
+	writers := []struct {
+		name       string
+		statements int
+		write      func(tx *sqlx.Tx, cards []models.ScryfallCard) error
+	}{
+		{"per-row", len(cards), upsertCardRowsPerRow},
+		{"multi-row", 1, upsertCardRows},
+	}
+
+	for _, roundTrip := range []time.Duration{0, 250 * time.Microsecond} {
+		for _, writer := range writers {
+			b.Run(fmt.Sprintf("%s/round-trip=%s", writer.name, roundTrip), func(b *testing.B) {
+				benchmarkWrite(b, writer.statements, roundTrip, func(tx *sqlx.Tx) error {
+					return writer.write(tx, cards)
+				})
+			})
+		}
+	}
+}
+
+// BenchmarkExecBulkInsert measures building and executing multi-row statements for batches of various sizes,
+// including batches large enough to be split to stay under the placeholder limit.
+func BenchmarkExecBulkInsert(b *testing.B) {
+	for _, size := range []int{1, 100, 1000, 5000} {
+		rows := make([][]interface{}, 0, size)
+		for _, card := range benchmarkCards(size) {
+			rows = append(rows, cardValues(card))
+		}
+		statements := (size + maxPlaceholders/len(cardColumns) - 1) / (maxPlaceholders / len(cardColumns))
+
+		b.Run(fmt.Sprintf("rows=%d", size), func(b *testing.B) {
+			benchmarkWrite(b, statements, 0, func(tx *sqlx.Tx) error {
+				return execBulkInsert(tx, "INSERT INTO cards", cardColumns, onDuplicateKeyUpdate(cardColumns), rows)
+			})
+		})
+	}
+}
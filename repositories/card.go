@@ -1,8 +1,13 @@
 package repositories
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/BrandonWade/blackblade-batch/models"
 	"github.com/jmoiron/sqlx"
@@ -11,13 +16,41 @@ import (
 
 // CardRepository interface for working with a cardRepository
 type CardRepository interface {
-	UpsertCards(cards []models.ScryfallCard) error
-	GenerateCardFacesJSON() error
-	GenerateCardSetsJSON() error
-	GenerateSets() error
-	InsertTypes(types []string) error
-	GenerateRulingsJSON() error
-	InsertRulings(rulings []models.ScryfallRuling) error
+	UpsertCards(ctx context.Context, cards []models.ScryfallCard) (models.CardWriteStats, error)
+	StageCards(ctx context.Context, cards []models.ScryfallCard) error
+	ResetStagedCards(ctx context.Context) error
+	ResetStagedLegalities(ctx context.Context) error
+	MergeStagedCards(ctx context.Context) (models.CardWriteStats, error)
+	UpsertOracleCards(ctx context.Context, cards []models.ScryfallCard) error
+	GenerateOracleCardsJSON(ctx context.Context, full bool) error
+	UpsertCardArtwork(ctx context.Context, cards []models.ScryfallCard) error
+	GenerateCardArtworkJSON(ctx context.Context) error
+	UpsertCardPrints(ctx context.Context, cards []models.ScryfallCard) error
+	GenerateCardLanguagesJSON(ctx context.Context) error
+	UpsertCardLocalizations(ctx context.Context, cards []models.ScryfallCard) error
+	GenerateCardFacesJSON(ctx context.Context, full bool) error
+	GenerateCardSetsJSON(ctx context.Context, full bool) error
+	GenerateSets(ctx context.Context) error
+	InsertTypes(ctx context.Context, types []string) error
+	GenerateRulingsJSON(ctx context.Context, full bool) error
+	GenerateLegalities(ctx context.Context) (int64, error)
+	RestoreDerivedTables(ctx context.Context) ([]string, error)
+	InsertPriceSnapshot(ctx context.Context, snapshotAt time.Time) error
+	GeneratePriceTrends(ctx context.Context, windows []int, tolerance time.Duration, suspiciousRatio float64, moversPerScope int) error
+	RemoveUnseenCards(ctx context.Context, bulkType string, maxPercent int) (int64, error)
+	RemoveUnseenRulings(ctx context.Context, bulkType string, maxPercent int) (int64, error)
+	RestoreCards(ctx context.Context, scryfallIDs []string) error
+	RestoreRulings(ctx context.Context, keys []string) error
+	RemoveUnseenOracleCards(ctx context.Context, bulkType string, maxPercent int) (int64, error)
+	RemoveUnseenCardArtwork(ctx context.Context, bulkType string, maxPercent int) (int64, error)
+	RemoveUnseenCardPrints(ctx context.Context, bulkType string, maxPercent int) (int64, error)
+	RestoreOracleCards(ctx context.Context, oracleIDs []string) error
+	RestoreCardArtwork(ctx context.Context, scryfallIDs []string) error
+	RestoreCardPrints(ctx context.Context, scryfallIDs []string) error
+	ClearChangedCards(ctx context.Context) error
+	ClearChangedRulings(ctx context.Context) error
+	ClearChangedOracleCards(ctx context.Context) error
+	InsertRulings(ctx context.Context, rulings []models.ScryfallRuling) (int64, error)
 }
 
 type cardRepository struct {
@@ -33,78 +66,164 @@ func NewCardRepository(logger *logrus.Logger, db *sqlx.DB) CardRepository {
 	}
 }
 
-// UpsertCards upserts cards into the database
-func (c *cardRepository) UpsertCards(cards []models.ScryfallCard) error {
-	tx, err := c.db.Begin()
+// UpsertCards upserts cards into the database, removing any multiverse IDs, frame effects and faces of the
+// cards that are no longer present. Only the prices of cards whose content is unchanged are written. It returns
+// the number of cards inserted, changed and unchanged, and the number of rows removed.
+func (c *cardRepository) UpsertCards(ctx context.Context, cards []models.ScryfallCard) (models.CardWriteStats, error) {
+	var stats models.CardWriteStats
+	err := retryOnLockContention(func() error {
+		var err error
+		stats, err = c.upsertCardBatch(ctx, cards)
+		return err
+	})
+
+	return stats, err
+}
+
+func (c *cardRepository) upsertCardBatch(ctx context.Context, cards []models.ScryfallCard) (models.CardWriteStats, error) {
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return models.CardWriteStats{}, err
+	}
+
+	batch := make([]models.ScryfallCard, len(cards))
+	for i, card := range cards {
+		c.setLayout(&card)
+		batch[i] = card
+	}
+
+	marker, err := changeMarker(tx)
 	if err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
+			return models.CardWriteStats{}, rollbackErr
 		}
 
-		return err
+		return models.CardWriteStats{}, err
 	}
 
-	for _, card := range cards {
-		c.setLayout(&card)
-		cardID, err := c.upsertCard(tx, card)
-		if err != nil {
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				return rollbackErr
-			}
+	hashes, err := getCardHashes(tx, batch)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return models.CardWriteStats{}, rollbackErr
+		}
 
-			return err
+		return models.CardWriteStats{}, &StatementError{"SELECT FROM cards", err}
+	}
+
+	stats := models.CardWriteStats{}
+	changed := make([]models.ScryfallCard, 0, len(batch))
+	for _, card := range batch {
+		hash, ok := hashes[card.ID]
+		switch {
+		case !ok:
+			stats.Inserted++
+		case hash != contentHash(card):
+			stats.Changed++
+		default:
+			stats.Unchanged++
+			continue
 		}
 
-		err = insertCardMultiverseIDs(tx, cardID, card.MultiverseIDs)
-		if err != nil {
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				return rollbackErr
-			}
+		changed = append(changed, card)
+	}
 
-			return err
+	err = upsertCardRows(tx, changed)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return models.CardWriteStats{}, rollbackErr
 		}
 
-		err = insertCardFrameEffects(tx, cardID, card.FrameEffects)
-		if err != nil {
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				return rollbackErr
-			}
+		return models.CardWriteStats{}, &StatementError{"INSERT INTO cards", err}
+	}
 
-			return err
+	cardIDs, err := getCardIDs(tx, batch)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return models.CardWriteStats{}, rollbackErr
 		}
 
-		err = upsertCardPrices(tx, cardID, card.Prices)
-		if err != nil {
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				return rollbackErr
-			}
+		return models.CardWriteStats{}, &StatementError{"SELECT FROM cards", err}
+	}
 
-			return err
+	changedIDs := make(map[string]int64, len(changed))
+	for _, card := range changed {
+		changedIDs[card.ID] = cardIDs[card.ID]
+	}
+
+	err = insertCardMultiverseIDs(tx, changedIDs, changed)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return models.CardWriteStats{}, rollbackErr
 		}
 
-		cardFaces := c.getCardFaces(card)
-		for i, cardFace := range cardFaces {
-			_, err = c.upsertCardFace(tx, cardID, i, card.Colors, cardFace)
-			if err != nil {
-				if rollbackErr := tx.Rollback(); rollbackErr != nil {
-					return rollbackErr
-				}
+		return models.CardWriteStats{}, &StatementError{"INSERT INTO card_multiverse_ids", err}
+	}
 
-				return err
-			}
+	err = insertCardFrameEffects(tx, changedIDs, changed)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return models.CardWriteStats{}, rollbackErr
 		}
+
+		return models.CardWriteStats{}, &StatementError{"INSERT INTO card_frame_effects", err}
+	}
+
+	err = upsertCardPrices(tx, cardIDs, batch)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return models.CardWriteStats{}, rollbackErr
+		}
+
+		return models.CardWriteStats{}, &StatementError{"INSERT INTO card_prices", err}
+	}
+
+	stats.Removed, err = c.deleteStaleCardChildren(tx, changedIDs, changed)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return models.CardWriteStats{}, rollbackErr
+		}
+
+		return models.CardWriteStats{}, err
+	}
+
+	err = c.upsertCardFaces(tx, changedIDs, changed)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return models.CardWriteStats{}, rollbackErr
+		}
+
+		return models.CardWriteStats{}, &StatementError{"INSERT INTO card_faces", err}
+	}
+
+	// A deleted face leaves no changed row behind, so every card in the batch is recorded if any face was deleted
+	err = recordChangedCards(tx, cardIDs, marker, stats.Removed.Faces > 0)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return models.CardWriteStats{}, rollbackErr
+		}
+
+		return models.CardWriteStats{}, err
+	}
+
+	err = stageCardLegalities(tx, batch)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return models.CardWriteStats{}, rollbackErr
+		}
+
+		return models.CardWriteStats{}, &StatementError{"INSERT INTO card_legalities_staging", err}
 	}
 
 	err = tx.Commit()
 	if err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
+			return models.CardWriteStats{}, rollbackErr
 		}
 
-		return err
+		return models.CardWriteStats{}, err
 	}
 
-	return nil
+	return stats, nil
 }
 
 func (c *cardRepository) setLayout(card *models.ScryfallCard) {
@@ -115,111 +234,47 @@ func (c *cardRepository) setLayout(card *models.ScryfallCard) {
 	}
 }
 
-func (c *cardRepository) upsertCard(tx *sql.Tx, card models.ScryfallCard) (int64, error) {
-	result, err := tx.Exec(`INSERT INTO cards (
-		scryfall_id,
-		oracle_id,
-		tcgplayer_id,
-		card_back_id,
-		cmc,
-		name,
-		set_code,
-		set_name,
-		collector_number,
-		rarity,
-		layout,
-		border_color,
-		frame,
-		released_at,
-		has_foil,
-		has_nonfoil,
-		is_oversized,
-		is_reserved,
-		is_booster,
-		is_full_art,
-		is_textless,
-		is_reprint,
-		has_highres_image,
-		rulings_uri,
-		scryfall_uri
-	) VALUES (
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?
-	) ON DUPLICATE KEY UPDATE
-		scryfall_id = ?,
-		oracle_id = ?,
-		tcgplayer_id = ?,
-		card_back_id = ?,
-		cmc = ?,
-		name = ?,
-		set_code = ?,
-		set_name = ?,
-		collector_number = ?,
-		rarity = ?,
-		layout = ?,
-		border_color = ?,
-		frame = ?,
-		released_at = ?,
-		has_foil = ?,
-		has_nonfoil = ?,
-		is_oversized = ?,
-		is_reserved = ?,
-		is_booster = ?,
-		is_full_art = ?,
-		is_textless = ?,
-		is_reprint = ?,
-		has_highres_image = ?,
-		rulings_uri = ?,
-		scryfall_uri = ?
-	`,
-		card.ID,
-		card.OracleID,
-		card.TCGPlayerID,
-		card.CardBackID,
-		card.CMC,
-		card.Name,
-		card.Set,
-		card.SetName,
-		card.CollectorNumber,
-		card.Rarity,
-		card.Layout,
-		card.BorderColor,
-		card.Frame,
-		card.ReleasedAt,
-		card.Foil,
-		card.Nonfoil,
-		card.Oversized,
-		card.Reserved,
-		card.Booster,
-		card.FullArt,
-		card.Textless,
-		card.Reprint,
-		card.HighresImage,
-		card.RulingsURI,
-		card.ScryfallURI,
+var cardColumns = []string{
+	"scryfall_id",
+	"oracle_id",
+	"tcgplayer_id",
+	"card_back_id",
+	"cmc",
+	"name",
+	"set_code",
+	"set_name",
+	"collector_number",
+	"rarity",
+	"layout",
+	"border_color",
+	"frame",
+	"released_at",
+	"has_foil",
+	"has_nonfoil",
+	"is_oversized",
+	"is_reserved",
+	"is_booster",
+	"is_full_art",
+	"is_textless",
+	"is_reprint",
+	"has_highres_image",
+	"rulings_uri",
+	"scryfall_uri",
+	"content_hash",
+}
+
+func upsertCardRows(tx *sqlx.Tx, cards []models.ScryfallCard) error {
+	rows := make([][]interface{}, 0, len(cards))
+	for _, card := range cards {
+		rows = append(rows, cardValues(card))
+	}
+
+	return execBulkInsert(tx, "INSERT INTO cards", cardColumns, onDuplicateKeyUpdate(cardColumns), rows)
+}
+
+// cardValues returns the values of cardColumns for the provided card.
+func cardValues(card models.ScryfallCard) []interface{} {
+	return []interface{}{
 		card.ID,
 		card.OracleID,
 		card.TCGPlayerID,
@@ -245,108 +300,169 @@ func (c *cardRepository) upsertCard(tx *sql.Tx, card models.ScryfallCard) (int64
 		card.HighresImage,
 		card.RulingsURI,
 		card.ScryfallURI,
+		contentHash(card),
+	}
+}
+
+// contentHash returns a hash of the content of the provided card, leaving out the fields that change from day
+// to day without the card itself changing, such as prices, so that unchanged cards can be skipped.
+func contentHash(card models.ScryfallCard) string {
+	card.Prices = models.ScryfallPrices{}
+	card.EDHRecRank = 0
+
+	content, err := json.Marshal(card)
+	if err != nil {
+		// An empty hash never matches, so the card is always written
+		return ""
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(content))
+}
+
+// getCardHashes returns the content hash of each of the provided cards already in the database, keyed by scryfall ID.
+func getCardHashes(tx *sqlx.Tx, cards []models.ScryfallCard) (map[string]string, error) {
+	scryfallIDs := make([]string, 0, len(cards))
+	for _, card := range cards {
+		scryfallIDs = append(scryfallIDs, card.ID)
+	}
+
+	query, args, err := sqlx.In(`SELECT
+		c.scryfall_id,
+		c.content_hash
+		FROM cards c
+		WHERE c.scryfall_id IN (?)
+	`,
+		scryfallIDs,
 	)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	cardID, err := result.LastInsertId()
+	rows := []struct {
+		ScryfallID  string `db:"scryfall_id"`
+		ContentHash string `db:"content_hash"`
+	}{}
+	err = tx.Select(&rows, tx.Rebind(query), args...)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	// When using ON DUPLICATE KEY UPDATE, MySQL does allow you to return the ID for the existing row
-	// using LAST_INSERT_ID(id), however this causes the AUTO_INCREMENT value to be increased by 2 per
-	// row every time the batch runs as every row is always updated.
-	if cardID == 0 {
-		err := c.db.Get(&cardID, `SELECT
-			id
-			FROM cards c
-			WHERE c.scryfall_id = ?
-		`,
-			card.ID,
-		)
-		if err != nil {
-			return 0, err
-		}
+	hashes := make(map[string]string, len(rows))
+	for _, row := range rows {
+		hashes[row.ScryfallID] = row.ContentHash
 	}
 
-	return cardID, nil
+	return hashes, nil
 }
 
-func insertCardMultiverseIDs(tx *sql.Tx, cardID int64, multiverseIDs []int) error {
-	for _, multiverseID := range multiverseIDs {
-		_, err := tx.Exec(`INSERT IGNORE INTO card_multiverse_ids (
-			card_id,
-			multiverse_id
-		) VALUES (
-			?,
-			?
-		)
-		`,
-			cardID,
-			multiverseID,
-		)
-		if err != nil {
-			return err
+// getCardIDs returns the ID of each of the provided cards keyed by scryfall ID.
+//
+// When using ON DUPLICATE KEY UPDATE, MySQL does allow you to return the ID for the existing row
+// using LAST_INSERT_ID(id), however this causes the AUTO_INCREMENT value to be increased by 2 per
+// row every time the batch runs as every row is always updated. Multi-row inserts also only report
+// the first ID, so the IDs are looked up afterwards instead.
+func getCardIDs(tx *sqlx.Tx, cards []models.ScryfallCard) (map[string]int64, error) {
+	scryfallIDs := make([]string, 0, len(cards))
+	for _, card := range cards {
+		scryfallIDs = append(scryfallIDs, card.ID)
+	}
+
+	query, args, err := sqlx.In(`SELECT
+		c.id,
+		c.scryfall_id
+		FROM cards c
+		WHERE c.scryfall_id IN (?)
+	`,
+		scryfallIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []struct {
+		ID         int64  `db:"id"`
+		ScryfallID string `db:"scryfall_id"`
+	}{}
+	err = tx.Select(&rows, tx.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	cardIDs := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		cardIDs[row.ScryfallID] = row.ID
+	}
+
+	return cardIDs, nil
+}
+
+func insertCardMultiverseIDs(tx *sqlx.Tx, cardIDs map[string]int64, cards []models.ScryfallCard) error {
+	return execBulkInsert(tx, "INSERT IGNORE INTO card_multiverse_ids", []string{"card_id", "multiverse_id"}, "", cardMultiverseIDRows(cardIDs, cards))
+}
+
+// cardMultiverseIDRows returns a (card_id, multiverse_id) row for each multiverse ID of the provided cards.
+func cardMultiverseIDRows(cardIDs map[string]int64, cards []models.ScryfallCard) [][]interface{} {
+	rows := [][]interface{}{}
+	for _, card := range cards {
+		for _, multiverseID := range card.MultiverseIDs {
+			rows = append(rows, []interface{}{
+				cardIDs[card.ID],
+				multiverseID,
+			})
 		}
 	}
 
-	return nil
+	return rows
 }
 
-func insertCardFrameEffects(tx *sql.Tx, cardID int64, frameEffects []string) error {
-	for _, frameEffect := range frameEffects {
-		_, err := tx.Exec(`INSERT IGNORE INTO card_frame_effects (
-			card_id,
-			frame_effect
-		) VALUES (
-			?,
-			?
-		)
-		`,
-			cardID,
-			frameEffect,
-		)
-		if err != nil {
-			return err
+func insertCardFrameEffects(tx *sqlx.Tx, cardIDs map[string]int64, cards []models.ScryfallCard) error {
+	return execBulkInsert(tx, "INSERT IGNORE INTO card_frame_effects", []string{"card_id", "frame_effect"}, "", cardFrameEffectRows(cardIDs, cards))
+}
+
+// cardFrameEffectRows returns a (card_id, frame_effect) row for each frame effect of the provided cards.
+func cardFrameEffectRows(cardIDs map[string]int64, cards []models.ScryfallCard) [][]interface{} {
+	rows := [][]interface{}{}
+	for _, card := range cards {
+		for _, frameEffect := range card.FrameEffects {
+			rows = append(rows, []interface{}{
+				cardIDs[card.ID],
+				frameEffect,
+			})
 		}
 	}
 
-	return nil
+	return rows
 }
 
-func upsertCardPrices(tx *sql.Tx, cardID int64, prices models.ScryfallPrices) error {
-	_, err := tx.Exec(`INSERT INTO card_prices (
-		card_id,
-		usd,
-		usd_foil,
-		eur,
-		tix
-	) VALUES (
-		?,
-		?,
-		?,
-		?,
-		?
-	) ON DUPLICATE KEY UPDATE
-		usd = ?,
-		usd_foil = ?,
-		eur = ?,
-		tix = ?
-	`,
-		cardID,
-		prices.USD,
-		prices.USDFoil,
-		prices.EUR,
-		prices.Tix,
+var cardPriceColumns = []string{
+	"usd",
+	"usd_foil",
+	"usd_etched",
+	"eur",
+	"eur_foil",
+	"tix",
+}
+
+func upsertCardPrices(tx *sqlx.Tx, cardIDs map[string]int64, cards []models.ScryfallCard) error {
+	rows := make([][]interface{}, 0, len(cards))
+	for _, card := range cards {
+		rows = append(rows, append([]interface{}{cardIDs[card.ID]}, cardPriceValues(card.Prices)...))
+	}
+
+	columns := append([]string{"card_id"}, cardPriceColumns...)
+	return execBulkInsert(tx, "INSERT INTO card_prices", columns, onDuplicateKeyUpdate(cardPriceColumns), rows)
+}
+
+// cardPriceValues returns the values of cardPriceColumns for the provided prices.
+func cardPriceValues(prices models.ScryfallPrices) []interface{} {
+	return []interface{}{
 		prices.USD,
 		prices.USDFoil,
+		prices.USDEtched,
 		prices.EUR,
+		prices.EURFoil,
 		prices.Tix,
-	)
-
-	return err
+	}
 }
 
 func (c *cardRepository) getCardFaces(card models.ScryfallCard) []models.ScryfallCardFace {
@@ -419,115 +535,56 @@ func (c *cardRepository) getDerivedType(cardType string) string {
 	return ""
 }
 
-func (c *cardRepository) upsertCardFace(tx *sql.Tx, cardID int64, index int, cardColors []string, cardFace models.ScryfallCardFace) (int64, error) {
+var cardFaceColumns = []string{
+	"is_white",
+	"is_blue",
+	"is_black",
+	"is_red",
+	"is_green",
+	"artist",
+	"flavor_text",
+	"illustration_id",
+	"image_small",
+	"image_normal",
+	"image_large",
+	"image_png",
+	"image_art_crop",
+	"image_border_crop",
+	"mana_cost",
+	"name",
+	"oracle_text",
+	"power",
+	"toughness",
+	"loyalty",
+	"type_line",
+	"derived_type",
+	"watermark",
+	"printed_name",
+	"printed_type_line",
+	"printed_text",
+}
+
+func (c *cardRepository) upsertCardFaces(tx *sqlx.Tx, cardIDs map[string]int64, cards []models.ScryfallCard) error {
+	rows := [][]interface{}{}
+	for _, card := range cards {
+		for i, cardFace := range c.getCardFaces(card) {
+			rows = append(rows, append([]interface{}{cardIDs[card.ID], i}, cardFaceValues(card.Colors, cardFace)...))
+		}
+	}
+
+	columns := append([]string{"card_id", "face_index"}, cardFaceColumns...)
+	return execBulkInsert(tx, "INSERT INTO card_faces", columns, onDuplicateKeyUpdate(cardFaceColumns), rows)
+}
+
+// cardFaceValues returns the values of cardFaceColumns for the provided card face.
+func cardFaceValues(cardColors []string, cardFace models.ScryfallCardFace) []interface{} {
 	isWhite := contains(cardColors, "W") || contains(cardFace.Colors, "W")
 	isBlue := contains(cardColors, "U") || contains(cardFace.Colors, "U")
 	isBlack := contains(cardColors, "B") || contains(cardFace.Colors, "B")
 	isRed := contains(cardColors, "R") || contains(cardFace.Colors, "R")
 	isGreen := contains(cardColors, "G") || contains(cardFace.Colors, "G")
 
-	result, err := tx.Exec(`INSERT INTO card_faces (
-		card_id,
-		face_index,
-		is_white,
-		is_blue,
-		is_black,
-		is_red,
-		is_green,
-		artist,
-		flavor_text,
-		illustration_id,
-		image_small,
-		image_normal,
-		image_large,
-		image_png,
-		image_art_crop,
-		image_border_crop,
-		mana_cost,
-		name,
-		oracle_text,
-		power,
-		toughness,
-		loyalty,
-		type_line,
-		derived_type,
-		watermark
-	) VALUES (
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?,
-		?
-	) ON DUPLICATE KEY UPDATE
-		is_white = ?,
-		is_blue = ?,
-		is_black = ?,
-		is_red = ?,
-		is_green = ?,
-		artist = ?,
-		flavor_text = ?,
-		illustration_id = ?,
-		image_small = ?,
-		image_normal = ?,
-		image_large = ?,
-		image_png = ?,
-		image_art_crop = ?,
-		image_border_crop = ?,
-		mana_cost = ?,
-		name = ?,
-		oracle_text = ?,
-		power = ?,
-		toughness = ?,
-		loyalty = ?,
-		type_line = ?,
-		derived_type = ?,
-		watermark = ?
-	`,
-		cardID,
-		index,
-		isWhite,
-		isBlue,
-		isBlack,
-		isRed,
-		isGreen,
-		cardFace.Artist,
-		cardFace.FlavorText,
-		cardFace.IllustrationID,
-		cardFace.ImageURIs.Small,
-		cardFace.ImageURIs.Normal,
-		cardFace.ImageURIs.Large,
-		cardFace.ImageURIs.PNG,
-		cardFace.ImageURIs.ArtCrop,
-		cardFace.ImageURIs.BorderCrop,
-		cardFace.ManaCost,
-		cardFace.Name,
-		cardFace.OracleText,
-		cardFace.Power,
-		cardFace.Toughness,
-		cardFace.Loyalty,
-		cardFace.TypeLine,
-		cardFace.DerivedType,
-		cardFace.Watermark,
+	return []interface{}{
 		isWhite,
 		isBlue,
 		isBlack,
@@ -551,22 +608,21 @@ func (c *cardRepository) upsertCardFace(tx *sql.Tx, cardID int64, index int, car
 		cardFace.TypeLine,
 		cardFace.DerivedType,
 		cardFace.Watermark,
-	)
-	if err != nil {
-		return 0, err
+		cardFace.PrintedName,
+		cardFace.PrintedTypeLine,
+		cardFace.PrintedText,
 	}
+}
 
-	cardFaceID, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
+// GenerateCardFacesJSON calculates card face info per card saves the result as JSON to the card row. Unless full
+// is set, only the changed cards are recalculated.
+func (c *cardRepository) GenerateCardFacesJSON(ctx context.Context, full bool) error {
+	filter := ""
+	if !full {
+		filter = "WHERE c.id IN (" + changedCardIDs + ")"
 	}
 
-	return cardFaceID, nil
-}
-
-// GenerateCardFacesJSON calculates card face info per card saves the result as JSON to the card row.
-func (c *cardRepository) GenerateCardFacesJSON() error {
-	_, err := c.db.Exec(`UPDATE cards c
+	_, err := c.db.ExecContext(ctx, fmt.Sprintf(`UPDATE cards c
 		INNER JOIN (
 			SELECT
 			c.id card_id,
@@ -587,90 +643,88 @@ func (c *cardRepository) GenerateCardFacesJSON() error {
 				'power', f.power,
 				'toughness', f.toughness,
 				'loyalty', f.loyalty,
-				'artist', f.artist
+				'artist', f.artist,
+				'printed_name', f.printed_name,
+				'printed_type_line', f.printed_type_line,
+				'printed_text', f.printed_text,
+				'localizations', COALESCE((
+					SELECT JSON_ARRAYAGG(JSON_OBJECT(
+						'lang', l.lang,
+						'printed_name', JSON_UNQUOTE(JSON_EXTRACT(l.faces_json, CONCAT('$[', f.face_index, '].printed_name'))),
+						'printed_type_line', JSON_UNQUOTE(JSON_EXTRACT(l.faces_json, CONCAT('$[', f.face_index, '].printed_type_line'))),
+						'printed_text', JSON_UNQUOTE(JSON_EXTRACT(l.faces_json, CONCAT('$[', f.face_index, '].printed_text')))
+					))
+					FROM card_localizations l
+					WHERE l.oracle_id = c.oracle_id
+					AND l.set_code = c.set_code
+					AND l.collector_number = c.collector_number
+				), JSON_ARRAY())
 			)) faces
 			FROM cards c
 			INNER JOIN card_faces f ON f.card_id = c.id
+			%s
 			GROUP BY c.id
 		) a
 		SET c.faces_json = a.faces
 		WHERE c.id = a.card_id
-	`)
+	`, filter))
 
 	return err
 }
 
-// GenerateCardSetsJSON calculates card set info per card saves the result as JSON to the card row.
-func (c *cardRepository) GenerateCardSetsJSON() error {
-	tx, err := c.db.Begin()
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
-		return err
-	}
-
-	_, err = tx.Exec(`TRUNCATE TABLE card_sets_list`)
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
-		return err
-	}
-
-	_, err = tx.Exec(`INSERT INTO card_sets_list (oracle_id, sets_json)
+// cardSetsQuery aggregates the printings of each distinct card, formatted with an extra condition on cards c
+const cardSetsQuery = `SELECT
+	a.oracle_id,
+	JSON_ARRAYAGG(JSON_OBJECT(
+		'card_id', a.card_id,
+		'name', a.name,
+		'set_name', a.set_name,
+		'set_code', a.set_code,
+		'price', a.price,
+		'faces_json', a.faces_json,
+		'layout', a.layout
+	)) sets_json
+	FROM (
 		SELECT
-		a.oracle_id,
-		JSON_ARRAYAGG(JSON_OBJECT(
-			'card_id', a.card_id,
-			'name', a.name,
-			'set_name', a.set_name,
-			'set_code', a.set_code,
-			'price', a.price,
-			'faces_json', a.faces_json,
-			'layout', a.layout
-		)) sets
-		FROM (
-			SELECT
-			c.id card_id,
-			c.oracle_id,
-			c.name,
-			c.set_name,
-			c.set_code,
-			IF(p.usd != "", p.usd, p.usd_foil) price,
-			c.faces_json,
-			c.layout
-			FROM cards c
-			INNER JOIN card_prices p ON p.card_id = c.id
-			GROUP BY c.id
-			ORDER BY c.released_at DESC
-		) a
-		GROUP BY a.oracle_id
-	`)
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
-		return err
+		c.id card_id,
+		c.oracle_id,
+		c.name,
+		c.set_name,
+		c.set_code,
+		IF(p.usd != "", p.usd, p.usd_foil) price,
+		c.faces_json,
+		c.layout
+		FROM cards c
+		INNER JOIN card_prices p ON p.card_id = c.id
+		WHERE c.removed_at IS NULL
+		%s
+		GROUP BY c.id
+		ORDER BY c.released_at DESC
+	) a
+	GROUP BY a.oracle_id
+`
+
+// GenerateCardSetsJSON calculates card set info per card saves the result as JSON to the card row. Unless full
+// is set, only the distinct cards with a changed printing are recalculated.
+func (c *cardRepository) GenerateCardSetsJSON(ctx context.Context, full bool) error {
+	var err error
+	filter := ""
+	if full {
+		err = c.rebuildTable(ctx, "card_sets_list", "oracle_id", "INSERT INTO %s (oracle_id, sets_json) "+fmt.Sprintf(cardSetsQuery, ""))
+	} else {
+		filter = "AND c.oracle_id IN (" + changedCardOracleIDs + ")"
+		err = c.refreshDerivedRows(ctx, "card_sets_list", "oracle_id", "sets_json", fmt.Sprintf(cardSetsQuery, filter), changedCardOracleIDs)
 	}
-
-	err = tx.Commit()
 	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
 		return err
 	}
 
-	_, err = c.db.Exec(`UPDATE cards c
+	_, err = c.db.ExecContext(ctx, fmt.Sprintf(`UPDATE cards c
 		INNER JOIN card_sets_list s ON s.oracle_id = c.oracle_id
 		SET c.card_sets_list_id = s.id
 		WHERE c.oracle_id = s.oracle_id
-	`)
+		%s
+	`, filter))
 	if err != nil {
 		return err
 	}
@@ -678,59 +732,28 @@ func (c *cardRepository) GenerateCardSetsJSON() error {
 	return nil
 }
 
-func (c *cardRepository) GenerateSets() error {
-	tx, err := c.db.Begin()
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
-		return err
-	}
-
-	_, err = tx.Exec(`TRUNCATE TABLE sets`)
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
-		return err
-	}
-
-	_, err = tx.Exec(`INSERT INTO sets (set_code, set_name)
+// GenerateSets calculates the distinct sets of the cards in the database.
+func (c *cardRepository) GenerateSets(ctx context.Context) error {
+	return c.rebuildTable(ctx, "sets", "", `INSERT INTO %s (set_code, set_name)
 		SELECT DISTINCT
 		c.set_code,
 		c.set_name
 		FROM cards c
+		WHERE c.removed_at IS NULL
 		ORDER BY c.set_name
 	`)
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
-		return err
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
-		return err
-	}
+}
 
-	return nil
+// InsertTypes inserts any of the provided card types that don't already exist
+func (c *cardRepository) InsertTypes(ctx context.Context, types []string) error {
+	return retryOnLockContention(func() error {
+		return c.insertTypes(ctx, types)
+	})
 }
 
-func (c *cardRepository) InsertTypes(types []string) error {
-	tx, err := c.db.Begin()
+func (c *cardRepository) insertTypes(ctx context.Context, types []string) error {
+	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
 		return err
 	}
 
@@ -741,6 +764,7 @@ func (c *cardRepository) InsertTypes(types []string) error {
 			cardType,
 		)
 		if err != nil {
+			err = &StatementError{"INSERT INTO types", err}
 			if rollbackErr := tx.Rollback(); rollbackErr != nil {
 				return rollbackErr
 			}
@@ -761,40 +785,65 @@ func (c *cardRepository) InsertTypes(types []string) error {
 	return nil
 }
 
-func (c *cardRepository) InsertRulings(rulings []models.ScryfallRuling) error {
-	tx, err := c.db.Begin()
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
+// InsertRulings inserts the provided rulings into the database and returns the number of new rulings. Rulings
+// already in the database are left unchanged.
+func (c *cardRepository) InsertRulings(ctx context.Context, rulings []models.ScryfallRuling) (int64, error) {
+	var inserted int64
+	err := retryOnLockContention(func() error {
+		var err error
+		inserted, err = c.insertRulingBatch(ctx, rulings)
 		return err
+	})
+
+	return inserted, err
+}
+
+func (c *cardRepository) insertRulingBatch(ctx context.Context, rulings []models.ScryfallRuling) (int64, error) {
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
 	}
 
+	changed := [][]interface{}{}
 	for _, ruling := range rulings {
-		_, err := c.insertRuling(tx, ruling)
+		inserted, err := c.insertRuling(tx.Tx, ruling)
 		if err != nil {
+			err = &StatementError{"INSERT INTO card_rulings", err}
 			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				return rollbackErr
+				return 0, rollbackErr
 			}
 
-			return err
+			return 0, err
+		}
+
+		if inserted {
+			changed = append(changed, []interface{}{ruling.OracleID})
+		}
+	}
+
+	err = execBulkInsert(tx, "INSERT IGNORE INTO changed_rulings", []string{"oracle_id"}, "", changed)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return 0, rollbackErr
 		}
+
+		return 0, &StatementError{"INSERT INTO changed_rulings", err}
 	}
 
 	err = tx.Commit()
 	if err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
+			return 0, rollbackErr
 		}
 
-		return err
+		return 0, err
 	}
 
-	return nil
+	return int64(len(changed)), nil
 }
 
-func (c *cardRepository) insertRuling(tx *sql.Tx, ruling models.ScryfallRuling) (int64, error) {
+// insertRuling inserts the provided ruling and returns whether it is new. Rulings already in the database are left unchanged.
+func (c *cardRepository) insertRuling(tx *sql.Tx, ruling models.ScryfallRuling) (bool, error) {
 	result, err := tx.Exec(`INSERT IGNORE INTO card_rulings (
 		oracle_id,
 		comment_hash,
@@ -816,83 +865,61 @@ func (c *cardRepository) insertRuling(tx *sql.Tx, ruling models.ScryfallRuling)
 		ruling.Comment,
 	)
 	if err != nil {
-		return 0, err
+		return false, err
 	}
 
-	rulingID, err := result.LastInsertId()
+	inserted, err := result.RowsAffected()
 	if err != nil {
-		return 0, err
+		return false, err
 	}
 
-	return rulingID, nil
+	return inserted > 0, nil
 }
 
-// GenerateRulingsJSON aggregates the rulings for each distinct card in the database and saves the result.
-func (c *cardRepository) GenerateRulingsJSON() error {
-	tx, err := c.db.Begin()
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
-		return err
-	}
-
-	_, err = tx.Exec(`TRUNCATE TABLE card_rulings_list`)
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
-		return err
-	}
-
-	_, err = tx.Exec(`INSERT INTO card_rulings_list (oracle_id, rulings_json)
+// cardRulingsQuery aggregates the rulings of each distinct card, formatted with an extra condition on rulings r
+const cardRulingsQuery = `SELECT
+	a.oracle_id,
+	JSON_ARRAYAGG(JSON_OBJECT(
+		'id', a.id,
+		'published_at', a.published_at,
+		'comment', a.comment
+	)) rulings_json
+	FROM (
 		SELECT
-		a.oracle_id,
-		JSON_ARRAYAGG(JSON_OBJECT(
-			'id', a.id,
-			'published_at', a.published_at,
-			'comment', a.comment
-		)) rulings
-		FROM (
-			SELECT
-			r.id,
-			r.oracle_id,
-			r.published_at,
-			r.comment
-			FROM card_rulings r
-			ORDER BY r.oracle_id, r.published_at
-		) a
-		GROUP BY a.oracle_id
-	`)
+		r.id,
+		r.oracle_id,
+		r.published_at,
+		r.comment
+		FROM card_rulings r
+		WHERE r.removed_at IS NULL
+		%s
+		ORDER BY r.oracle_id, r.published_at
+	) a
+	GROUP BY a.oracle_id
+`
+
+// GenerateRulingsJSON aggregates the rulings for each distinct card in the database and saves the result. Unless
+// full is set, only the distinct cards with a changed ruling are recalculated.
+func (c *cardRepository) GenerateRulingsJSON(ctx context.Context, full bool) error {
+	var err error
+	filter := ""
+	if full {
+		err = c.rebuildTable(ctx, "card_rulings_list", "oracle_id", "INSERT INTO %s (oracle_id, rulings_json) "+fmt.Sprintf(cardRulingsQuery, ""))
+	} else {
+		filter = "AND r.oracle_id IN (" + changedRulingOracleIDs + ")"
+		err = c.refreshDerivedRows(ctx, "card_rulings_list", "oracle_id", "rulings_json", fmt.Sprintf(cardRulingsQuery, filter), changedRulingOracleIDs)
+	}
 	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
 		return err
 	}
 
-	_, err = tx.Exec(`UPDATE cards c
+	_, err = c.db.ExecContext(ctx, fmt.Sprintf(`UPDATE cards c
 		INNER JOIN card_rulings_list r ON r.oracle_id = c.oracle_id
 		SET c.card_rulings_list_id = r.id
 		WHERE c.oracle_id = r.oracle_id
-	`)
+		%s
+	`, filter))
 	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
-		return err
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
-
 		return err
 	}
 
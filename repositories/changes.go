@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Subqueries selecting the keys whose derived data is out of date
+const (
+	changedCardIDs                = "SELECT card_id FROM changed_cards"
+	changedCardOracleIDs          = "SELECT oracle_id FROM changed_cards"
+	changedRulingOracleIDs        = "SELECT oracle_id FROM changed_rulings"
+	changedOracleCardIDs          = "SELECT oracle_id FROM changed_oracle_cards"
+	recordChangedCardsQuery       = "INSERT IGNORE INTO changed_cards (card_id, oracle_id) SELECT c.id, c.oracle_id FROM cards c"
+	recordChangedRulingQuery      = "INSERT IGNORE INTO changed_rulings (oracle_id) SELECT DISTINCT r.oracle_id FROM card_rulings r"
+	recordChangedOracleCardsQuery = "INSERT IGNORE INTO changed_oracle_cards (oracle_id) SELECT o.oracle_id FROM oracle_cards o"
+)
+
+// cardChangedSince is a condition on cards c matching the cards whose row, faces or prices were inserted or
+// modified at or after the time in the provided SQL expression.
+func cardChangedSince(since string) string {
+	return fmt.Sprintf(`(c.changed_at >= %[1]s
+		OR EXISTS (SELECT 1 FROM card_faces f WHERE f.card_id = c.id AND f.changed_at >= %[1]s)
+		OR EXISTS (SELECT 1 FROM card_prices p WHERE p.card_id = c.id AND p.changed_at >= %[1]s))`, since)
+}
+
+// changeMarker returns the current time of the database, to find the rows a transaction goes on to change.
+func changeMarker(tx *sqlx.Tx) (string, error) {
+	var marker string
+	err := tx.Get(&marker, "SELECT NOW(6)")
+	if err != nil {
+		return "", &StatementError{"SELECT NOW", err}
+	}
+
+	return marker, nil
+}
+
+// recordChangedCards records the provided cards as changed if their row, faces or prices changed since the
+// marker, or unconditionally if all is set.
+func recordChangedCards(tx *sqlx.Tx, cardIDs map[string]int64, marker string, all bool) error {
+	if len(cardIDs) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(cardIDs))
+	for _, id := range cardIDs {
+		ids = append(ids, id)
+	}
+
+	query, args, err := sqlx.In(recordChangedCardsQuery+`
+		WHERE c.id IN (?)
+		AND (? OR `+cardChangedSince("?")+`)
+	`,
+		ids,
+		all,
+		marker,
+		marker,
+		marker,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(tx.Rebind(query), args...)
+	if err != nil {
+		return &StatementError{"INSERT INTO changed_cards", err}
+	}
+
+	return nil
+}
+
+// refreshDerivedRows recalculates the rows of a derived table for the changed keys only. The table is copied into
+// a <table>_new shadow table, the changed rows are recalculated in the copy, and the copy is swapped in like a full
+// rebuild, so the previous generation is kept as <table>_old after every run. The query selects the key and value
+// column of the recalculated rows, and changed selects the changed keys. Rows keep their ids, and rows for keys that
+// no longer have any data are deleted.
+func (c *cardRepository) refreshDerivedRows(ctx context.Context, table, key, column, query, changed string) error {
+	newTable := table + "_new"
+
+	statements := []struct {
+		name  string
+		query string
+	}{
+		{"DROP TABLE " + newTable, "DROP TABLE IF EXISTS " + newTable},
+		{"CREATE TABLE " + newTable, fmt.Sprintf("CREATE TABLE %s LIKE %s", newTable, table)},
+		{"INSERT INTO " + newTable, fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", newTable, table)},
+		{"UPDATE " + newTable, fmt.Sprintf(`UPDATE %[1]s t
+			INNER JOIN (%[4]s) a ON a.%[2]s = t.%[2]s
+			SET t.%[3]s = a.%[3]s
+		`, newTable, key, column, query)},
+		{"INSERT INTO " + newTable, fmt.Sprintf(`INSERT INTO %[1]s (%[2]s, %[3]s)
+			SELECT a.%[2]s, a.%[3]s
+			FROM (%[4]s) a
+			LEFT JOIN %[1]s t ON t.%[2]s = a.%[2]s
+			WHERE t.%[2]s IS NULL
+		`, newTable, key, column, query)},
+		{"DELETE FROM " + newTable, fmt.Sprintf(`DELETE t
+			FROM %[1]s t
+			LEFT JOIN (%[4]s) a ON a.%[2]s = t.%[2]s
+			WHERE t.%[2]s IN (%[5]s)
+			AND a.%[2]s IS NULL
+		`, newTable, key, column, query, changed)},
+	}
+
+	for _, statement := range statements {
+		_, err := c.db.ExecContext(ctx, statement.query)
+		if err != nil {
+			return &StatementError{statement.name, err}
+		}
+	}
+
+	return c.swapTable(ctx, table)
+}
+
+// ClearChangedCards forgets the changed cards once the data derived from them has been recalculated.
+func (c *cardRepository) ClearChangedCards(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, "DELETE FROM changed_cards")
+
+	return err
+}
+
+// ClearChangedRulings forgets the changed rulings once the data derived from them has been recalculated.
+func (c *cardRepository) ClearChangedRulings(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, "DELETE FROM changed_rulings")
+
+	return err
+}
+
+// ClearChangedOracleCards forgets the changed oracle cards once their JSON has been recalculated.
+func (c *cardRepository) ClearChangedOracleCards(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, "DELETE FROM changed_oracle_cards")
+
+	return err
+}
@@ -0,0 +1,117 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+)
+
+var ruleDecisionColumns = []string{
+	"bulk_type",
+	"scryfall_id",
+	"name",
+	"included",
+	"rule",
+}
+
+// DeleteRuleDecisions removes the inclusion rule decisions recorded for the specified bulk data type.
+func (b *batchRepository) DeleteRuleDecisions(ctx context.Context, bulkType string) error {
+	_, err := b.db.ExecContext(ctx, `DELETE FROM card_rule_decisions
+		WHERE bulk_type = ?
+	`,
+		bulkType,
+	)
+
+	return err
+}
+
+// UpsertRuleDecisions records the provided inclusion rule decisions, replacing any made for the same card.
+func (b *batchRepository) UpsertRuleDecisions(ctx context.Context, decisions []models.RuleDecision) error {
+	rows := make([][]interface{}, 0, len(decisions))
+	for _, decision := range decisions {
+		rows = append(rows, []interface{}{
+			decision.BulkType,
+			decision.ScryfallID,
+			decision.Name,
+			decision.Included,
+			decision.Rule,
+		})
+	}
+
+	return execBulkInsertTx(ctx, b.db, "INSERT INTO card_rule_decisions", ruleDecisionColumns, onDuplicateKeyUpdate(ruleDecisionColumns[2:]), rows)
+}
+
+// GetRuleCounts returns the number of cards each inclusion rule included or excluded for the specified bulk data type.
+func (b *batchRepository) GetRuleCounts(ctx context.Context, bulkType string) ([]models.RuleCount, error) {
+	counts := []models.RuleCount{}
+	err := b.db.SelectContext(ctx, &counts, `SELECT
+		d.rule,
+		d.included,
+		COUNT(*) count
+		FROM card_rule_decisions d
+		WHERE d.bulk_type = ?
+		GROUP BY d.rule, d.included
+		ORDER BY count DESC, d.rule
+	`,
+		bulkType,
+	)
+	if err != nil {
+		return []models.RuleCount{}, err
+	}
+
+	return counts, nil
+}
+
+// InsertRuleReport records the provided summary of a run's inclusion rule decisions.
+func (b *batchRepository) InsertRuleReport(ctx context.Context, report models.RuleReport, summary string) error {
+	_, err := b.db.ExecContext(ctx, `INSERT INTO card_rule_reports (
+		bulk_type,
+		bulk_id,
+		updated_at,
+		included,
+		excluded,
+		summary
+	) VALUES (
+		?,
+		?,
+		?,
+		?,
+		?,
+		?
+	)
+	`,
+		report.BulkType,
+		report.BulkID,
+		report.UpdatedAt,
+		report.Included,
+		report.Excluded,
+		summary,
+	)
+
+	return err
+}
+
+// GetRuleDecisions returns the inclusion rule decisions recorded for cards with the specified scryfall ID or name.
+func (b *batchRepository) GetRuleDecisions(ctx context.Context, query string) ([]models.RuleDecision, error) {
+	decisions := []models.RuleDecision{}
+	err := b.db.SelectContext(ctx, &decisions, `SELECT
+		d.bulk_type,
+		d.scryfall_id,
+		d.name,
+		d.included,
+		d.rule,
+		d.decided_at
+		FROM card_rule_decisions d
+		WHERE d.scryfall_id = ?
+		OR d.name = ?
+		ORDER BY d.name, d.bulk_type, d.scryfall_id
+	`,
+		query,
+		query,
+	)
+	if err != nil {
+		return []models.RuleDecision{}, err
+	}
+
+	return decisions, nil
+}
@@ -0,0 +1,59 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// StatementError is returned when a statement fails while writing to the database
+type StatementError struct {
+	Statement string
+	Err       error
+}
+
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("error executing %s: %s", e.Statement, e.Err.Error())
+}
+
+// Unwrap returns the underlying database error
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+// MySQL error numbers for transactions that failed because of lock contention with another transaction
+const (
+	errLockWaitTimeout = 1205
+	errLockDeadlock    = 1213
+)
+
+const maxLockContentionAttempts = 3
+
+// retryOnLockContention runs the provided transaction again if it fails because it deadlocked or timed out
+// waiting on a lock held by another batch being written concurrently.
+func retryOnLockContention(transaction func() error) error {
+	var err error
+	for attempt := 0; attempt < maxLockContentionAttempts; attempt++ {
+		err = transaction()
+
+		var mysqlErr *mysql.MySQLError
+		if !errors.As(err, &mysqlErr) || (mysqlErr.Number != errLockDeadlock && mysqlErr.Number != errLockWaitTimeout) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// TombstoneThresholdError is returned when more rows would be marked as removed than the configured threshold allows
+type TombstoneThresholdError struct {
+	Table      string
+	Unseen     int64
+	Total      int64
+	MaxPercent int
+}
+
+func (e *TombstoneThresholdError) Error() string {
+	return fmt.Sprintf("refusing to mark %d of %d %s rows as removed, more than %d%%", e.Unseen, e.Total, e.Table, e.MaxPercent)
+}
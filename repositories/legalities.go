@@ -0,0 +1,167 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+	"github.com/jmoiron/sqlx"
+)
+
+var cardLegalityColumns = []string{
+	"oracle_id",
+	"format",
+	"status",
+}
+
+// cardLegalityRows returns a row for the legality of each of the provided cards in each format. Printings
+// share their legalities, so each oracle ID is only included once.
+func cardLegalityRows(cards []models.ScryfallCard) [][]interface{} {
+	rows := [][]interface{}{}
+	seen := map[string]bool{}
+	for _, card := range cards {
+		if card.OracleID == "" || seen[card.OracleID] {
+			continue
+		}
+		seen[card.OracleID] = true
+
+		l := card.Legalities
+		formats := [][2]string{
+			{"standard", l.Standard},
+			{"future", l.Future},
+			{"historic", l.Historic},
+			{"pioneer", l.Pioneer},
+			{"modern", l.Modern},
+			{"legacy", l.Legacy},
+			{"pauper", l.Pauper},
+			{"vintage", l.Vintage},
+			{"penny", l.Penny},
+			{"commander", l.Commander},
+			{"brawl", l.Brawl},
+			{"duel", l.Duel},
+			{"oldschool", l.Oldschool},
+			{"gladiator", l.Gladiator},
+			{"premodern", l.Premodern},
+		}
+
+		for _, format := range formats {
+			if format[1] != "" {
+				rows = append(rows, []interface{}{card.OracleID, format[0], format[1]})
+			}
+		}
+	}
+
+	return rows
+}
+
+// stageCardLegalities records the legalities of the provided cards to be published by GenerateLegalities.
+func stageCardLegalities(tx *sqlx.Tx, cards []models.ScryfallCard) error {
+	return execBulkInsert(tx, "INSERT INTO card_legalities_staging", cardLegalityColumns, onDuplicateKeyUpdate(cardLegalityColumns[2:]), cardLegalityRows(cards))
+}
+
+// ResetStagedLegalities forgets the legalities observed by the previous run, so that only those observed in the
+// current run are published.
+func (c *cardRepository) ResetStagedLegalities(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, `TRUNCATE TABLE card_legalities_staging`)
+
+	return err
+}
+
+// GenerateLegalities records any legality that differs from the last published value in legality_changes,
+// publishes the legalities observed in the last run and rebuilds card_legalities_list from them. It returns the
+// number of legality changes recorded.
+func (c *cardRepository) GenerateLegalities(ctx context.Context) (int64, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// Cards seen for the first time have no previous legality, so they are not recorded as changes
+	result, err := tx.Exec(`INSERT INTO legality_changes (oracle_id, format, old_status, new_status)
+		SELECT
+		s.oracle_id,
+		s.format,
+		l.status,
+		s.status
+		FROM card_legalities_staging s
+		INNER JOIN card_legalities l ON l.oracle_id = s.oracle_id AND l.format = s.format
+		WHERE l.status != s.status
+	`)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return 0, rollbackErr
+		}
+
+		return 0, &StatementError{"INSERT INTO legality_changes", err}
+	}
+
+	changes, err := result.RowsAffected()
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return 0, rollbackErr
+		}
+
+		return 0, err
+	}
+
+	_, err = tx.Exec(`INSERT INTO card_legalities (oracle_id, format, status)
+		SELECT
+		s.oracle_id,
+		s.format,
+		s.status
+		FROM card_legalities_staging s
+		ON DUPLICATE KEY UPDATE status = VALUES(status)
+	`)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return 0, rollbackErr
+		}
+
+		return 0, &StatementError{"INSERT INTO card_legalities", err}
+	}
+
+	// Formats no longer reported for a card that was observed are dropped rather than published forever
+	_, err = tx.Exec(`DELETE l
+		FROM card_legalities l
+		INNER JOIN (SELECT DISTINCT oracle_id FROM card_legalities_staging) o ON o.oracle_id = l.oracle_id
+		LEFT JOIN card_legalities_staging s ON s.oracle_id = l.oracle_id AND s.format = l.format
+		WHERE s.oracle_id IS NULL
+	`)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return 0, rollbackErr
+		}
+
+		return 0, &StatementError{"DELETE FROM card_legalities", err}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return 0, rollbackErr
+		}
+
+		return 0, err
+	}
+
+	err = c.rebuildTable(ctx, "card_legalities_list", "oracle_id", `INSERT INTO %s (oracle_id, legalities_json)
+		SELECT
+		l.oracle_id,
+		JSON_OBJECTAGG(l.format, l.status) legalities
+		FROM card_legalities l
+		GROUP BY l.oracle_id
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	// Cards whose legalities were all removed no longer have a row in the rebuilt table
+	_, err = c.db.ExecContext(ctx, `UPDATE cards c
+		LEFT JOIN card_legalities_list l ON l.oracle_id = c.oracle_id
+		SET c.card_legalities_list_id = l.id
+	`)
+	if err != nil {
+		return 0, &StatementError{"UPDATE cards", err}
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,124 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+	"github.com/jmoiron/sqlx"
+)
+
+var cardLocalizationColumns = []string{
+	"oracle_id",
+	"set_code",
+	"collector_number",
+	"lang",
+	"scryfall_id",
+	"printed_name",
+	"printed_type_line",
+	"printed_text",
+	"faces_json",
+}
+
+// localizedCardFace is the printed text of a single face of a localized card
+type localizedCardFace struct {
+	FaceIndex       int    `json:"face_index"`
+	PrintedName     string `json:"printed_name"`
+	PrintedTypeLine string `json:"printed_type_line"`
+	PrintedText     string `json:"printed_text"`
+}
+
+// UpsertCardLocalizations upserts the printed text of the provided localized cards into the database, and records
+// the cards whose localized text changed so that their derived data is recalculated
+func (c *cardRepository) UpsertCardLocalizations(ctx context.Context, cards []models.ScryfallCard) error {
+	rows := make([][]interface{}, 0, len(cards))
+	for _, card := range cards {
+		c.setLayout(&card)
+
+		faces := []localizedCardFace{}
+		for i, cardFace := range c.getCardFaces(card) {
+			faces = append(faces, localizedCardFace{
+				FaceIndex:       i,
+				PrintedName:     cardFace.PrintedName,
+				PrintedTypeLine: cardFace.PrintedTypeLine,
+				PrintedText:     cardFace.PrintedText,
+			})
+		}
+
+		facesJSON, err := json.Marshal(faces)
+		if err != nil {
+			return err
+		}
+
+		rows = append(rows, []interface{}{
+			card.OracleID,
+			card.Set,
+			card.CollectorNumber,
+			card.Lang,
+			card.ID,
+			card.PrintedName,
+			card.PrintedTypeLine,
+			card.PrintedText,
+			string(facesJSON),
+		})
+	}
+
+	return retryOnLockContention(func() error {
+		return c.writeCardLocalizations(ctx, rows)
+	})
+}
+
+// writeCardLocalizations writes the provided localization rows and records the cards they changed in a single
+// transaction.
+func (c *cardRepository) writeCardLocalizations(ctx context.Context, rows [][]interface{}) error {
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = upsertCardLocalizationRows(tx, rows)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func upsertCardLocalizationRows(tx *sqlx.Tx, rows [][]interface{}) error {
+	marker, err := changeMarker(tx)
+	if err != nil {
+		return err
+	}
+
+	err = execBulkInsert(tx, "INSERT INTO card_localizations", cardLocalizationColumns, onDuplicateKeyUpdate(cardLocalizationColumns[4:]), rows)
+	if err != nil {
+		return &StatementError{"INSERT INTO card_localizations", err}
+	}
+
+	// Rewriting a localization with the same text leaves its changed_at untouched, so only the printings whose
+	// localized text was inserted or modified are recorded
+	_, err = tx.Exec(recordChangedCardsQuery+`
+		INNER JOIN card_localizations l ON l.oracle_id = c.oracle_id
+		AND l.set_code = c.set_code
+		AND l.collector_number = c.collector_number
+		WHERE l.changed_at >= ?
+	`, marker)
+	if err != nil {
+		return &StatementError{"INSERT INTO changed_cards", err}
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+	"github.com/jmoiron/sqlx"
+)
+
+var oracleCardColumns = []string{
+	"oracle_id",
+	"scryfall_id",
+	"name",
+	"layout",
+	"mana_cost",
+	"cmc",
+	"type_line",
+	"oracle_text",
+	"power",
+	"toughness",
+	"loyalty",
+	"colors",
+	"color_identity",
+	"keywords",
+	"edhrec_rank",
+	"faces_json",
+}
+
+// oracleCardFace is the subset of a card face stored for the canonical version of a card
+type oracleCardFace struct {
+	Name       string `json:"name"`
+	ManaCost   string `json:"mana_cost"`
+	TypeLine   string `json:"type_line"`
+	OracleText string `json:"oracle_text"`
+	Power      string `json:"power"`
+	Toughness  string `json:"toughness"`
+	Loyalty    string `json:"loyalty"`
+	Image      string `json:"image"`
+}
+
+// UpsertOracleCards upserts the canonical version of each card into the database, and records the oracle cards
+// that changed so that their JSON is recalculated
+func (c *cardRepository) UpsertOracleCards(ctx context.Context, cards []models.ScryfallCard) error {
+	rows := make([][]interface{}, 0, len(cards))
+	for _, card := range cards {
+		c.setLayout(&card)
+
+		faces := []oracleCardFace{}
+		for _, cardFace := range c.getCardFaces(card) {
+			faces = append(faces, oracleCardFace{
+				Name:       cardFace.Name,
+				ManaCost:   cardFace.ManaCost,
+				TypeLine:   cardFace.TypeLine,
+				OracleText: cardFace.OracleText,
+				Power:      cardFace.Power,
+				Toughness:  cardFace.Toughness,
+				Loyalty:    cardFace.Loyalty,
+				Image:      cardFace.ImageURIs.Normal,
+			})
+		}
+
+		facesJSON, err := json.Marshal(faces)
+		if err != nil {
+			return err
+		}
+
+		colors, err := jsonArray(card.Colors)
+		if err != nil {
+			return err
+		}
+
+		colorIdentity, err := jsonArray(card.ColorIdentity)
+		if err != nil {
+			return err
+		}
+
+		keywords, err := jsonArray(card.Keywords)
+		if err != nil {
+			return err
+		}
+
+		rows = append(rows, []interface{}{
+			card.OracleID,
+			card.ID,
+			card.Name,
+			card.Layout,
+			card.ManaCost,
+			card.CMC,
+			card.TypeLine,
+			card.OracleText,
+			card.Power,
+			card.Toughness,
+			card.Loyalty,
+			colors,
+			colorIdentity,
+			keywords,
+			card.EDHRecRank,
+			string(facesJSON),
+		})
+	}
+
+	return retryOnLockContention(func() error {
+		return c.writeOracleCards(ctx, rows)
+	})
+}
+
+// writeOracleCards writes the provided oracle card rows and records the oracle cards they changed in a single
+// transaction.
+func (c *cardRepository) writeOracleCards(ctx context.Context, rows [][]interface{}) error {
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = upsertOracleCardRows(tx, rows)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func upsertOracleCardRows(tx *sqlx.Tx, rows [][]interface{}) error {
+	marker, err := changeMarker(tx)
+	if err != nil {
+		return err
+	}
+
+	err = execBulkInsert(tx, "INSERT INTO oracle_cards", oracleCardColumns, onDuplicateKeyUpdate(oracleCardColumns[1:]), rows)
+	if err != nil {
+		return &StatementError{"INSERT INTO oracle_cards", err}
+	}
+
+	// Rewriting an oracle card with the same values leaves its changed_at untouched, so only the oracle cards that
+	// were inserted or modified are recorded
+	_, err = tx.Exec(recordChangedOracleCardsQuery+`
+		WHERE o.changed_at >= ?
+	`, marker)
+	if err != nil {
+		return &StatementError{"INSERT INTO changed_oracle_cards", err}
+	}
+
+	return nil
+}
+
+// GenerateOracleCardsJSON calculates the JSON for the canonical version of each card and saves the result to the
+// oracle card row. Unless full is set, only the changed oracle cards are recalculated.
+func (c *cardRepository) GenerateOracleCardsJSON(ctx context.Context, full bool) error {
+	filter := ""
+	if !full {
+		filter = "WHERE o.oracle_id IN (" + changedOracleCardIDs + ")"
+	}
+
+	_, err := c.db.ExecContext(ctx, `UPDATE oracle_cards o
+		SET o.card_json = JSON_OBJECT(
+			'oracle_id', o.oracle_id,
+			'scryfall_id', o.scryfall_id,
+			'name', o.name,
+			'layout', o.layout,
+			'mana_cost', o.mana_cost,
+			'cmc', o.cmc,
+			'type_line', o.type_line,
+			'oracle_text', o.oracle_text,
+			'power', o.power,
+			'toughness', o.toughness,
+			'loyalty', o.loyalty,
+			'colors', o.colors,
+			'color_identity', o.color_identity,
+			'keywords', o.keywords,
+			'edhrec_rank', o.edhrec_rank,
+			'faces', o.faces_json
+		)
+		`+filter)
+
+	return err
+}
@@ -0,0 +1,389 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// snapshotPrice is the price of a card in a snapshot, preferring the non-foil USD price
+const snapshotPrice = `CAST(NULLIF(IF(h.usd != '', h.usd, h.usd_foil), '') AS DECIMAL(10, 2))`
+
+// InsertPriceSnapshot appends the current price of every card to the price history. Snapshots are keyed by the
+// time the bulk data was updated, so recording the same snapshot more than once has no effect.
+func (c *cardRepository) InsertPriceSnapshot(ctx context.Context, snapshotAt time.Time) error {
+	_, err := c.db.ExecContext(ctx, `INSERT IGNORE INTO card_price_history (
+		scryfall_id,
+		snapshot_at,
+		usd,
+		usd_foil,
+		usd_etched,
+		eur,
+		eur_foil,
+		tix
+	)
+		SELECT
+		c.scryfall_id,
+		?,
+		p.usd,
+		p.usd_foil,
+		p.usd_etched,
+		p.eur,
+		p.eur_foil,
+		p.tix
+		FROM cards c
+		INNER JOIN card_prices p ON p.card_id = c.id
+		WHERE c.removed_at IS NULL
+	`,
+		snapshotAt,
+	)
+	if err != nil {
+		return &StatementError{"INSERT INTO card_price_history", err}
+	}
+
+	return nil
+}
+
+// snapshotTimeLayout is the format of card_price_history.snapshot_at as returned by the database
+const snapshotTimeLayout = "2006-01-02 15:04:05"
+
+// nearestSnapshot returns the snapshot closest to target, provided it was taken within tolerance of it. The
+// snapshots must be in ascending order.
+func nearestSnapshot(snapshots []time.Time, target time.Time, tolerance time.Duration) (time.Time, bool) {
+	var nearest time.Time
+	found := false
+	for _, snapshot := range snapshots {
+		distance := absDuration(snapshot.Sub(target))
+		if distance > tolerance {
+			continue
+		}
+
+		if !found || distance < absDuration(nearest.Sub(target)) {
+			nearest = snapshot
+			found = true
+		}
+	}
+
+	return nearest, found
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+
+	return d
+}
+
+// GeneratePriceTrends recalculates the price trends of every card over each of the provided windows, in days, by
+// comparing the latest price snapshot with the snapshot taken closest to that long before it. Snapshots follow
+// the time Scryfall updated the bulk data, which drifts from day to day, so the start snapshot may be up to
+// tolerance either side of the window. Windows without a snapshot within tolerance are skipped rather than
+// calculated from an older snapshot. Changes where the price moved by at least suspiciousRatio times in either
+// direction are flagged as suspicious and excluded from the top moversPerScope gainers and losers in each set and format.
+func (c *cardRepository) GeneratePriceTrends(ctx context.Context, windows []int, tolerance time.Duration, suspiciousRatio float64, moversPerScope int) error {
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"price_trends", "oracle_price_trends", "price_movers", "price_movers_list"} {
+		_, err = tx.Exec("DELETE FROM " + table)
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+
+			return &StatementError{"DELETE FROM " + table, err}
+		}
+	}
+
+	snapshots := []string{}
+	err = tx.Select(&snapshots, `SELECT DISTINCT h.snapshot_at
+		FROM card_price_history h
+		ORDER BY h.snapshot_at
+	`)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+
+		return &StatementError{"SELECT FROM card_price_history", err}
+	}
+
+	snapshotTimes := make([]time.Time, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		snapshotAt, err := time.Parse(snapshotTimeLayout, snapshot)
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+
+			return err
+		}
+
+		snapshotTimes = append(snapshotTimes, snapshotAt)
+	}
+
+	for _, window := range windows {
+		if len(snapshotTimes) == 0 {
+			break
+		}
+
+		endAt := snapshotTimes[len(snapshotTimes)-1]
+		startAt, ok := nearestSnapshot(snapshotTimes, endAt.AddDate(0, 0, -window), tolerance)
+		if !ok {
+			continue
+		}
+
+		err = insertPriceTrends(tx, window, startAt.Format(snapshotTimeLayout), endAt.Format(snapshotTimeLayout), suspiciousRatio, moversPerScope)
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO price_movers_list (window_days, scope, scope_code, movers_json)
+		SELECT
+		b.window_days,
+		b.scope,
+		b.scope_code,
+		JSON_OBJECTAGG(CONCAT(b.direction, 's'), b.movers) movers
+		FROM (
+			SELECT
+			a.window_days,
+			a.scope,
+			a.scope_code,
+			a.direction,
+			JSON_ARRAYAGG(JSON_OBJECT(
+				'id', a.item_id,
+				'name', a.name,
+				'price_start', a.price_start,
+				'price_end', a.price_end,
+				'delta', a.delta,
+				'delta_pct', a.delta_pct
+			)) movers
+			FROM (
+				SELECT
+				m.window_days,
+				m.scope,
+				m.scope_code,
+				m.direction,
+				m.item_id,
+				m.name,
+				m.price_start,
+				m.price_end,
+				m.delta,
+				m.delta_pct
+				FROM price_movers m
+				ORDER BY m.window_days, m.scope, m.scope_code, m.direction, m.position
+			) a
+			GROUP BY a.window_days, a.scope, a.scope_code, a.direction
+		) b
+		GROUP BY b.window_days, b.scope, b.scope_code
+	`)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+
+		return &StatementError{"INSERT INTO price_movers_list", err}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// priceStatement is a named statement executed while calculating price trends
+type priceStatement struct {
+	name  string
+	query string
+	args  []interface{}
+}
+
+// insertPriceTrends calculates the price trends and movers for a single window.
+func insertPriceTrends(tx *sqlx.Tx, window int, startAt, endAt string, suspiciousRatio float64, moversPerScope int) error {
+	statements := []priceStatement{
+		{"INSERT INTO price_trends", fmt.Sprintf(`INSERT INTO price_trends (
+			scryfall_id,
+			window_days,
+			oracle_id,
+			set_code,
+			price_start,
+			price_end,
+			delta,
+			delta_pct,
+			is_suspicious
+		)
+			SELECT
+			c.scryfall_id,
+			?,
+			c.oracle_id,
+			c.set_code,
+			s.price,
+			e.price,
+			e.price - s.price,
+			(e.price - s.price) / s.price * 100,
+			GREATEST(e.price / s.price, s.price / e.price) >= ?
+			FROM (
+				SELECT h.scryfall_id, %[1]s price
+				FROM card_price_history h
+				WHERE h.snapshot_at = ?
+			) s
+			INNER JOIN (
+				SELECT h.scryfall_id, %[1]s price
+				FROM card_price_history h
+				WHERE h.snapshot_at = ?
+			) e ON e.scryfall_id = s.scryfall_id
+			INNER JOIN cards c ON c.scryfall_id = s.scryfall_id
+			WHERE s.price > 0
+			AND e.price > 0
+		`, snapshotPrice), []interface{}{window, suspiciousRatio, startAt, endAt}},
+		{"INSERT INTO oracle_price_trends", fmt.Sprintf(`INSERT INTO oracle_price_trends (
+			oracle_id,
+			window_days,
+			name,
+			price_start,
+			price_end,
+			delta,
+			delta_pct,
+			is_suspicious
+		)
+			SELECT
+			s.oracle_id,
+			?,
+			s.name,
+			s.price,
+			e.price,
+			e.price - s.price,
+			(e.price - s.price) / s.price * 100,
+			GREATEST(e.price / s.price, s.price / e.price) >= ?
+			FROM (
+				SELECT c.oracle_id, MIN(c.name) name, MIN(%[1]s) price
+				FROM card_price_history h
+				INNER JOIN cards c ON c.scryfall_id = h.scryfall_id
+				WHERE h.snapshot_at = ?
+				GROUP BY c.oracle_id
+			) s
+			INNER JOIN (
+				SELECT c.oracle_id, MIN(%[1]s) price
+				FROM card_price_history h
+				INNER JOIN cards c ON c.scryfall_id = h.scryfall_id
+				WHERE h.snapshot_at = ?
+				GROUP BY c.oracle_id
+			) e ON e.oracle_id = s.oracle_id
+			WHERE s.price > 0
+			AND e.price > 0
+		`, snapshotPrice), []interface{}{window, suspiciousRatio, startAt, endAt}},
+	}
+
+	directions := []struct {
+		name  string
+		order string
+		where string
+	}{
+		{"gainer", "DESC", "> 0"},
+		{"loser", "ASC", "< 0"},
+	}
+
+	for _, direction := range directions {
+		statements = append(statements, priceStatement{"INSERT INTO price_movers", fmt.Sprintf(`INSERT INTO price_movers (
+			window_days,
+			scope,
+			scope_code,
+			direction,
+			position,
+			item_id,
+			name,
+			price_start,
+			price_end,
+			delta,
+			delta_pct
+		)
+			SELECT
+			a.window_days,
+			'set',
+			a.set_code,
+			?,
+			a.position,
+			a.scryfall_id,
+			a.name,
+			a.price_start,
+			a.price_end,
+			a.delta,
+			a.delta_pct
+			FROM (
+				SELECT
+				t.*,
+				c.name,
+				ROW_NUMBER() OVER (PARTITION BY t.set_code ORDER BY t.delta %[1]s, t.scryfall_id) position
+				FROM price_trends t
+				INNER JOIN cards c ON c.scryfall_id = t.scryfall_id
+				WHERE t.window_days = ?
+				AND t.is_suspicious = 0
+				AND t.delta %[2]s
+			) a
+			WHERE a.position <= ?
+		`, direction.order, direction.where), []interface{}{direction.name, window, moversPerScope}}, priceStatement{"INSERT INTO price_movers", fmt.Sprintf(`INSERT INTO price_movers (
+			window_days,
+			scope,
+			scope_code,
+			direction,
+			position,
+			item_id,
+			name,
+			price_start,
+			price_end,
+			delta,
+			delta_pct
+		)
+			SELECT
+			a.window_days,
+			'format',
+			a.format,
+			?,
+			a.position,
+			a.oracle_id,
+			a.name,
+			a.price_start,
+			a.price_end,
+			a.delta,
+			a.delta_pct
+			FROM (
+				SELECT
+				t.*,
+				l.format,
+				ROW_NUMBER() OVER (PARTITION BY l.format ORDER BY t.delta %[1]s, t.oracle_id) position
+				FROM oracle_price_trends t
+				INNER JOIN card_legalities l ON l.oracle_id = t.oracle_id
+				WHERE t.window_days = ?
+				AND t.is_suspicious = 0
+				AND t.delta %[2]s
+				AND l.status IN ('legal', 'restricted')
+			) a
+			WHERE a.position <= ?
+		`, direction.order, direction.where), []interface{}{direction.name, window, moversPerScope}})
+	}
+
+	for _, statement := range statements {
+		_, err := tx.Exec(statement.query, statement.args...)
+		if err != nil {
+			return &StatementError{statement.name, err}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package repositories
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNearestSnapshot(t *testing.T) {
+	day := 24 * time.Hour
+	end := time.Date(2024, 3, 29, 9, 12, 0, 0, time.UTC)
+
+	// Daily snapshots, each taken a few hours either side of the time of the latest one
+	jittered := []time.Time{
+		end.Add(-14*day - 3*time.Hour),
+		end.Add(-9*day + 2*time.Hour),
+		end.Add(-8*day - 40*time.Minute),
+		end.Add(-7*day + 5*time.Minute),
+		end.Add(-6*day - 7*time.Hour),
+		end,
+	}
+
+	tests := []struct {
+		name      string
+		snapshots []time.Time
+		window    int
+		want      time.Time
+		wantOK    bool
+	}{
+		{"taken later in the day than a week ago", jittered, 7, end.Add(-7*day + 5*time.Minute), true},
+		{"taken earlier in the day than a week ago", []time.Time{end.Add(-8 * day), end.Add(-7*day - 3*time.Hour), end}, 7, end.Add(-7*day - 3*time.Hour), true},
+		{"closest either side", []time.Time{end.Add(-7*day - 2*time.Hour), end.Add(-7*day + time.Hour), end}, 7, end.Add(-7*day + time.Hour), true},
+		{"day skipped", []time.Time{end.Add(-14 * day), end.Add(-8 * day), end.Add(-6 * day), end}, 7, time.Time{}, false},
+		{"only just within tolerance", []time.Time{end.Add(-7*day - 12*time.Hour), end}, 7, end.Add(-7*day - 12*time.Hour), true},
+		{"only just outside tolerance", []time.Time{end.Add(-7*day - 12*time.Hour - time.Minute), end}, 7, time.Time{}, false},
+		{"history shorter than window", jittered, 30, time.Time{}, false},
+		{"two weeks", jittered, 14, end.Add(-14*day - 3*time.Hour), true},
+		{"no snapshots", nil, 7, time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := nearestSnapshot(test.snapshots, end.AddDate(0, 0, -test.window), 12*time.Hour)
+			if ok != test.wantOK || !got.Equal(test.want) {
+				t.Errorf("got %s, %t, want %s, %t", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
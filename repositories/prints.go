@@ -0,0 +1,85 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+)
+
+var cardPrintColumns = []string{
+	"scryfall_id",
+	"oracle_id",
+	"lang",
+	"name",
+	"printed_name",
+	"set_code",
+	"set_name",
+	"collector_number",
+	"released_at",
+	"image_normal",
+}
+
+// UpsertCardPrints upserts every printing of the provided cards, in any language, into the database
+func (c *cardRepository) UpsertCardPrints(ctx context.Context, cards []models.ScryfallCard) error {
+	rows := make([][]interface{}, 0, len(cards))
+	for _, card := range cards {
+		image := card.ImageURIs.Normal
+		if image == "" && len(card.CardFaces) > 0 {
+			image = card.CardFaces[0].ImageURIs.Normal
+		}
+
+		printedName := card.PrintedName
+		if printedName == "" && len(card.CardFaces) > 0 {
+			printedName = card.CardFaces[0].PrintedName
+		}
+
+		rows = append(rows, []interface{}{
+			card.ID,
+			card.OracleID,
+			card.Lang,
+			card.Name,
+			printedName,
+			card.Set,
+			card.SetName,
+			card.CollectorNumber,
+			card.ReleasedAt,
+			image,
+		})
+	}
+
+	return retryOnLockContention(func() error {
+		return execBulkInsertTx(ctx, c.db, "INSERT INTO card_prints", cardPrintColumns, onDuplicateKeyUpdate(cardPrintColumns[1:]), rows)
+	})
+}
+
+// GenerateCardLanguagesJSON aggregates the printings in each language for each distinct card in the database and saves the result.
+func (c *cardRepository) GenerateCardLanguagesJSON(ctx context.Context) error {
+	return c.rebuildTable(ctx, "card_languages_list", "oracle_id", `INSERT INTO %s (oracle_id, languages_json)
+		SELECT
+		a.oracle_id,
+		JSON_ARRAYAGG(JSON_OBJECT(
+			'scryfall_id', a.scryfall_id,
+			'lang', a.lang,
+			'printed_name', a.printed_name,
+			'set_code', a.set_code,
+			'set_name', a.set_name,
+			'collector_number', a.collector_number,
+			'image', a.image_normal
+		)) languages
+		FROM (
+			SELECT
+			p.scryfall_id,
+			p.oracle_id,
+			p.lang,
+			p.printed_name,
+			p.set_code,
+			p.set_name,
+			p.collector_number,
+			p.image_normal
+			FROM card_prints p
+			WHERE p.removed_at IS NULL
+			ORDER BY p.oracle_id, p.lang, p.released_at DESC
+		) a
+		GROUP BY a.oracle_id
+	`)
+}
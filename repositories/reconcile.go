@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"strings"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// deleteStaleCardRows reconciles a child table of cards against the incoming rows. Any row belonging to one of
+// the provided cards whose (card_id, <column>) pair is not one of the provided rows is deleted. It returns the
+// number of rows deleted.
+func deleteStaleCardRows(tx *sqlx.Tx, table string, column string, cardIDs map[string]int64, rows [][]interface{}) (int64, error) {
+	if len(cardIDs) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]int64, 0, len(cardIDs))
+	for _, id := range cardIDs {
+		ids = append(ids, id)
+	}
+
+	query := "DELETE FROM " + table + " WHERE card_id IN (?)"
+	args := []interface{}{ids}
+	if len(rows) > 0 {
+		query += " AND (card_id, " + column + ") NOT IN (" + strings.TrimSuffix(strings.Repeat("(?, ?), ", len(rows)), ", ") + ")"
+		for _, row := range rows {
+			args = append(args, row...)
+		}
+	}
+
+	query, args, err := sqlx.In(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(tx.Rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// deleteStaleCardChildren removes the multiverse IDs, frame effects and faces of the provided cards that are no
+// longer present on the incoming cards.
+func (c *cardRepository) deleteStaleCardChildren(tx *sqlx.Tx, cardIDs map[string]int64, cards []models.ScryfallCard) (models.RemovedCardRows, error) {
+	removed := models.RemovedCardRows{}
+
+	var err error
+	removed.MultiverseIDs, err = deleteStaleCardRows(tx, "card_multiverse_ids", "multiverse_id", cardIDs, cardMultiverseIDRows(cardIDs, cards))
+	if err != nil {
+		return models.RemovedCardRows{}, &StatementError{"DELETE FROM card_multiverse_ids", err}
+	}
+
+	removed.FrameEffects, err = deleteStaleCardRows(tx, "card_frame_effects", "frame_effect", cardIDs, cardFrameEffectRows(cardIDs, cards))
+	if err != nil {
+		return models.RemovedCardRows{}, &StatementError{"DELETE FROM card_frame_effects", err}
+	}
+
+	faceKeys := [][]interface{}{}
+	for _, card := range cards {
+		for i := range c.getCardFaces(card) {
+			faceKeys = append(faceKeys, []interface{}{cardIDs[card.ID], i})
+		}
+	}
+
+	removed.Faces, err = deleteStaleCardRows(tx, "card_faces", "face_index", cardIDs, faceKeys)
+	if err != nil {
+		return models.RemovedCardRows{}, &StatementError{"DELETE FROM card_faces", err}
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,183 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+)
+
+// InsertRun records the start of a new batch run and returns its ID.
+func (b *batchRepository) InsertRun(ctx context.Context) (int64, error) {
+	result, err := b.db.ExecContext(ctx, `INSERT INTO batch_runs (
+		status
+	) VALUES (
+		?
+	)
+	`,
+		models.RunStatusRunning,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// FinishRun records the end of the specified batch run with the provided status and error message.
+func (b *batchRepository) FinishRun(ctx context.Context, id int64, status, runErr string) error {
+	_, err := b.db.ExecContext(ctx, `UPDATE batch_runs
+		SET finished_at = NOW(),
+		status = ?,
+		error = NULLIF(?, '')
+		WHERE id = ?
+	`,
+		status,
+		runErr,
+		id,
+	)
+
+	return err
+}
+
+// InsertRunBulkData records the statistics of a bulk data file processed by a batch run.
+func (b *batchRepository) InsertRunBulkData(ctx context.Context, data models.BatchRunBulkData) error {
+	_, err := b.db.ExecContext(ctx, `INSERT INTO batch_run_bulk_data (
+		run_id,
+		bulk_type,
+		bulk_id,
+		updated_at,
+		status,
+		decoded_count,
+		filtered_count,
+		written_count,
+		inserted_count,
+		updated_count,
+		unchanged_count,
+		failed_count,
+		stage_durations,
+		error
+	) VALUES (
+		?,
+		?,
+		?,
+		?,
+		?,
+		?,
+		?,
+		?,
+		?,
+		?,
+		?,
+		?,
+		?,
+		NULLIF(?, '')
+	)
+	ON DUPLICATE KEY UPDATE
+		bulk_id = VALUES(bulk_id),
+		updated_at = VALUES(updated_at),
+		status = VALUES(status),
+		decoded_count = VALUES(decoded_count),
+		filtered_count = VALUES(filtered_count),
+		written_count = VALUES(written_count),
+		inserted_count = VALUES(inserted_count),
+		updated_count = VALUES(updated_count),
+		unchanged_count = VALUES(unchanged_count),
+		failed_count = VALUES(failed_count),
+		stage_durations = VALUES(stage_durations),
+		error = VALUES(error)
+	`,
+		data.RunID,
+		data.BulkType,
+		data.BulkID,
+		data.UpdatedAt,
+		data.Status,
+		data.Decoded,
+		data.Filtered,
+		data.Written,
+		data.Inserted,
+		data.Updated,
+		data.Unchanged,
+		data.Failed,
+		data.StageDurations,
+		data.Error,
+	)
+
+	return err
+}
+
+// GetRuns returns the most recent batch runs, newest first.
+func (b *batchRepository) GetRuns(ctx context.Context, limit int) ([]models.BatchRun, error) {
+	runs := []models.BatchRun{}
+	err := b.db.SelectContext(ctx, &runs, `SELECT
+		r.id,
+		r.started_at,
+		COALESCE(r.finished_at, '') finished_at,
+		r.status,
+		COALESCE(r.error, '') error
+		FROM batch_runs r
+		ORDER BY r.id DESC
+		LIMIT ?
+	`,
+		limit,
+	)
+	if err != nil {
+		return []models.BatchRun{}, err
+	}
+
+	return runs, nil
+}
+
+// GetRun returns the specified batch run, or an empty BatchRun if it does not exist.
+func (b *batchRepository) GetRun(ctx context.Context, id int64) (models.BatchRun, error) {
+	run := models.BatchRun{}
+	err := b.db.GetContext(ctx, &run, `SELECT
+		r.id,
+		r.started_at,
+		COALESCE(r.finished_at, '') finished_at,
+		r.status,
+		COALESCE(r.error, '') error
+		FROM batch_runs r
+		WHERE r.id = ?
+	`,
+		id,
+	)
+	if err == sql.ErrNoRows {
+		return models.BatchRun{}, nil
+	} else if err != nil {
+		return models.BatchRun{}, err
+	}
+
+	return run, nil
+}
+
+// GetRunBulkData returns the statistics of each bulk data file processed by the specified batch run.
+func (b *batchRepository) GetRunBulkData(ctx context.Context, runID int64) ([]models.BatchRunBulkData, error) {
+	data := []models.BatchRunBulkData{}
+	err := b.db.SelectContext(ctx, &data, `SELECT
+		d.run_id,
+		d.bulk_type,
+		d.bulk_id,
+		d.updated_at,
+		d.status,
+		d.decoded_count,
+		d.filtered_count,
+		d.written_count,
+		d.inserted_count,
+		d.updated_count,
+		d.unchanged_count,
+		d.failed_count,
+		COALESCE(d.stage_durations, '[]') stage_durations,
+		COALESCE(d.error, '') error
+		FROM batch_run_bulk_data d
+		WHERE d.run_id = ?
+		ORDER BY d.bulk_type
+	`,
+		runID,
+	)
+	if err != nil {
+		return []models.BatchRunBulkData{}, err
+	}
+
+	return data, nil
+}
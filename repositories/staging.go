@@ -0,0 +1,348 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	stagingCardColumns = append(append(append([]string{}, cardColumns...), cardPriceColumns...), "multiverse_ids", "frame_effects")
+	stagingFaceColumns = append([]string{"scryfall_id", "face_index"}, cardFaceColumns...)
+	tsvEscaper         = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, "\x00", `\0`)
+	loadDataReaderID   uint64
+)
+
+// StageCards bulk loads cards into the staging tables to be merged later by MergeStagedCards
+func (c *cardRepository) StageCards(ctx context.Context, cards []models.ScryfallCard) error {
+	cardRows := make([][]interface{}, 0, len(cards))
+	faceRows := [][]interface{}{}
+	for _, card := range cards {
+		c.setLayout(&card)
+
+		multiverseIDs, err := jsonArray(card.MultiverseIDs)
+		if err != nil {
+			return err
+		}
+
+		frameEffects, err := jsonArray(card.FrameEffects)
+		if err != nil {
+			return err
+		}
+
+		row := append(cardValues(card), cardPriceValues(card.Prices)...)
+		cardRows = append(cardRows, append(row, multiverseIDs, frameEffects))
+
+		for i, cardFace := range c.getCardFaces(card) {
+			faceRows = append(faceRows, append([]interface{}{card.ID, i}, cardFaceValues(card.Colors, cardFace)...))
+		}
+	}
+
+	// The batch is staged in a single transaction, so that a card that fails to load leaves nothing behind
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = stageCardRows(tx, cardRows, faceRows, cards)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func stageCardRows(tx *sqlx.Tx, cardRows, faceRows [][]interface{}, cards []models.ScryfallCard) error {
+	err := loadData(tx, "cards_staging", stagingCardColumns, cardRows)
+	if err != nil {
+		return &StatementError{"LOAD DATA INTO cards_staging", err}
+	}
+
+	err = loadData(tx, "card_faces_staging", stagingFaceColumns, faceRows)
+	if err != nil {
+		return &StatementError{"LOAD DATA INTO card_faces_staging", err}
+	}
+
+	err = stageCardLegalities(tx, cards)
+	if err != nil {
+		return &StatementError{"INSERT INTO card_legalities_staging", err}
+	}
+
+	return nil
+}
+
+// ResetStagedCards removes any cards left in the staging tables
+func (c *cardRepository) ResetStagedCards(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, `TRUNCATE TABLE cards_staging`)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.ExecContext(ctx, `TRUNCATE TABLE card_faces_staging`)
+
+	return err
+}
+
+// MergeStagedCards merges the cards in the staging tables into the cards, card_faces, card_prices,
+// card_multiverse_ids and card_frame_effects tables, removing any multiverse IDs, frame effects and faces of
+// the staged cards that are no longer present. Only the prices of cards whose content is unchanged are written. It returns
+// the number of cards inserted, changed and unchanged, and the number of rows removed.
+func (c *cardRepository) MergeStagedCards(ctx context.Context) (models.CardWriteStats, error) {
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return models.CardWriteStats{}, err
+	}
+
+	counts := struct {
+		Inserted  int64 `db:"inserted"`
+		Changed   int64 `db:"changed"`
+		Unchanged int64 `db:"unchanged"`
+	}{}
+	err = tx.Get(&counts, `SELECT
+		COALESCE(SUM(c.id IS NULL), 0) inserted,
+		COALESCE(SUM(c.content_hash != s.content_hash), 0) changed,
+		COALESCE(SUM(c.content_hash = s.content_hash), 0) unchanged
+		FROM cards_staging s
+		LEFT JOIN cards c ON c.scryfall_id = s.scryfall_id
+	`)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return models.CardWriteStats{}, rollbackErr
+		}
+
+		return models.CardWriteStats{}, &StatementError{"SELECT FROM cards_staging", err}
+	}
+
+	stats := models.CardWriteStats{
+		Inserted:  counts.Inserted,
+		Changed:   counts.Changed,
+		Unchanged: counts.Unchanged,
+	}
+
+	statements := []struct {
+		name    string
+		query   string
+		removed *int64
+	}{
+		{"SET @merge_started", "SET @merge_started = NOW(6)", nil},
+		// The staged cards that are new or whose content changed, taken before the cards are overwritten with their
+		// new hashes. Only these cards have their row and child rows rewritten.
+		{"DROP TEMPORARY TABLE", "DROP TEMPORARY TABLE IF EXISTS changed_staged_cards", nil},
+		{"CREATE TEMPORARY TABLE", `CREATE TEMPORARY TABLE changed_staged_cards (PRIMARY KEY (scryfall_id))
+			SELECT s.scryfall_id
+			FROM cards_staging s
+			LEFT JOIN cards c ON c.scryfall_id = s.scryfall_id
+			WHERE c.content_hash IS NULL
+			OR c.content_hash != s.content_hash
+		`, nil},
+		{"INSERT INTO cards", fmt.Sprintf(`INSERT INTO cards (%s)
+			SELECT %s
+			FROM cards_staging s
+			INNER JOIN changed_staged_cards x ON x.scryfall_id = s.scryfall_id
+			%s
+		`, strings.Join(cardColumns, ", "), prefixColumns("s", cardColumns), onDuplicateKeyUpdate(cardColumns)), nil},
+		{"INSERT INTO card_prices", fmt.Sprintf(`INSERT INTO card_prices (card_id, %s)
+			SELECT
+			c.id,
+			%s
+			FROM cards_staging s
+			INNER JOIN cards c ON c.scryfall_id = s.scryfall_id
+			%s
+		`, strings.Join(cardPriceColumns, ", "), prefixColumns("s", cardPriceColumns), onDuplicateKeyUpdate(cardPriceColumns)), nil},
+		// A deleted face leaves no changed row behind, so cards losing a face are recorded as changed beforehand
+		{"INSERT INTO changed_cards", recordChangedCardsQuery + `
+			INNER JOIN cards_staging s ON s.scryfall_id = c.scryfall_id
+			INNER JOIN changed_staged_cards x ON x.scryfall_id = s.scryfall_id
+			WHERE EXISTS (
+				SELECT 1
+				FROM card_faces f
+				LEFT JOIN card_faces_staging fs ON fs.scryfall_id = s.scryfall_id AND fs.face_index = f.face_index
+				WHERE f.card_id = c.id
+				AND fs.scryfall_id IS NULL
+			)
+		`, nil},
+		{"DELETE FROM card_faces", `DELETE f
+			FROM card_faces f
+			INNER JOIN cards c ON c.id = f.card_id
+			INNER JOIN cards_staging s ON s.scryfall_id = c.scryfall_id
+			INNER JOIN changed_staged_cards x ON x.scryfall_id = s.scryfall_id
+			LEFT JOIN card_faces_staging fs ON fs.scryfall_id = s.scryfall_id AND fs.face_index = f.face_index
+			WHERE fs.scryfall_id IS NULL
+		`, &stats.Removed.Faces},
+		{"INSERT INTO card_faces", fmt.Sprintf(`INSERT INTO card_faces (card_id, face_index, %s)
+			SELECT
+			c.id,
+			f.face_index,
+			%s
+			FROM card_faces_staging f
+			INNER JOIN changed_staged_cards x ON x.scryfall_id = f.scryfall_id
+			INNER JOIN cards c ON c.scryfall_id = f.scryfall_id
+			%s
+		`, strings.Join(cardFaceColumns, ", "), prefixColumns("f", cardFaceColumns), onDuplicateKeyUpdate(cardFaceColumns)), nil},
+		{"DELETE FROM card_multiverse_ids", `DELETE m
+			FROM card_multiverse_ids m
+			INNER JOIN cards c ON c.id = m.card_id
+			INNER JOIN cards_staging s ON s.scryfall_id = c.scryfall_id
+			INNER JOIN changed_staged_cards x ON x.scryfall_id = s.scryfall_id
+			WHERE NOT JSON_CONTAINS(s.multiverse_ids, CAST(m.multiverse_id AS JSON))
+		`, &stats.Removed.MultiverseIDs},
+		{"INSERT INTO card_multiverse_ids", `INSERT IGNORE INTO card_multiverse_ids (card_id, multiverse_id)
+			SELECT
+			c.id,
+			m.multiverse_id
+			FROM cards_staging s
+			INNER JOIN changed_staged_cards x ON x.scryfall_id = s.scryfall_id
+			INNER JOIN cards c ON c.scryfall_id = s.scryfall_id
+			INNER JOIN JSON_TABLE(s.multiverse_ids, '$[*]' COLUMNS (multiverse_id INT PATH '$')) m
+		`, nil},
+		{"DELETE FROM card_frame_effects", `DELETE e
+			FROM card_frame_effects e
+			INNER JOIN cards c ON c.id = e.card_id
+			INNER JOIN cards_staging s ON s.scryfall_id = c.scryfall_id
+			INNER JOIN changed_staged_cards x ON x.scryfall_id = s.scryfall_id
+			WHERE NOT JSON_CONTAINS(s.frame_effects, JSON_QUOTE(e.frame_effect))
+		`, &stats.Removed.FrameEffects},
+		{"INSERT INTO card_frame_effects", `INSERT IGNORE INTO card_frame_effects (card_id, frame_effect)
+			SELECT
+			c.id,
+			e.frame_effect
+			FROM cards_staging s
+			INNER JOIN changed_staged_cards x ON x.scryfall_id = s.scryfall_id
+			INNER JOIN cards c ON c.scryfall_id = s.scryfall_id
+			INNER JOIN JSON_TABLE(s.frame_effects, '$[*]' COLUMNS (frame_effect VARCHAR(64) PATH '$')) e
+		`, nil},
+		{"INSERT INTO changed_cards", recordChangedCardsQuery + `
+			INNER JOIN cards_staging s ON s.scryfall_id = c.scryfall_id
+			WHERE ` + cardChangedSince("@merge_started"), nil},
+		{"DROP TEMPORARY TABLE", "DROP TEMPORARY TABLE changed_staged_cards", nil},
+	}
+
+	for _, statement := range statements {
+		result, err := tx.Exec(statement.query)
+		if err == nil && statement.removed != nil {
+			*statement.removed, err = result.RowsAffected()
+		}
+
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return models.CardWriteStats{}, rollbackErr
+			}
+
+			return models.CardWriteStats{}, &StatementError{statement.name, err}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return models.CardWriteStats{}, rollbackErr
+		}
+
+		return models.CardWriteStats{}, err
+	}
+
+	return stats, nil
+}
+
+// loadDataWarning is a row returned by SHOW WARNINGS
+type loadDataWarning struct {
+	Level   string `db:"Level"`
+	Code    int    `db:"Code"`
+	Message string `db:"Message"`
+}
+
+// loadData streams the provided rows into a table as TSV using LOAD DATA LOCAL INFILE. Rows replace any
+// existing rows with the same key, so loading the same rows again (e.g. after resuming a run) is safe. LOAD DATA
+// reports values it had to truncate or convert as warnings rather than errors, so any warning fails the load, the
+// same as it would fail an INSERT.
+func loadData(tx *sqlx.Tx, table string, columns []string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, row := range rows {
+		for i, value := range row {
+			if i > 0 {
+				buf.WriteByte('\t')
+			}
+
+			buf.WriteString(tsvField(value))
+		}
+
+		buf.WriteByte('\n')
+	}
+
+	name := fmt.Sprintf("%s-%d", table, atomic.AddUint64(&loadDataReaderID, 1))
+	mysql.RegisterReaderHandler(name, func() io.Reader {
+		return &buf
+	})
+	defer mysql.DeregisterReaderHandler(name)
+
+	_, err := tx.Exec(fmt.Sprintf(`LOAD DATA LOCAL INFILE 'Reader::%s'
+		REPLACE INTO TABLE %s
+		CHARACTER SET utf8mb4
+		(%s)
+	`, name, table, strings.Join(columns, ", ")))
+	if err != nil {
+		return err
+	}
+
+	warnings := []loadDataWarning{}
+	err = tx.Select(&warnings, "SHOW WARNINGS")
+	if err != nil {
+		return err
+	}
+
+	if len(warnings) > 0 {
+		return fmt.Errorf("%d warning(s) loading data, the first being %s %d: %s", len(warnings), warnings[0].Level, warnings[0].Code, warnings[0].Message)
+	}
+
+	return nil
+}
+
+func tsvField(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return `\N`
+	case bool:
+		if v {
+			return "1"
+		}
+
+		return "0"
+	case string:
+		return tsvEscaper.Replace(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func prefixColumns(alias string, columns []string) string {
+	prefixed := make([]string, 0, len(columns))
+	for _, column := range columns {
+		prefixed = append(prefixed, alias+"."+column)
+	}
+
+	return strings.Join(prefixed, ", ")
+}
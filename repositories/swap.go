@@ -0,0 +1,127 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// derivedTables are the tables regenerated with rebuildTable, whose previous generation can be restored
+var derivedTables = []string{
+	"card_sets_list",
+	"sets",
+	"card_rulings_list",
+	"card_artwork_list",
+	"card_languages_list",
+	"card_legalities_list",
+}
+
+// rebuildTable regenerates a derived table into a shadow copy and swaps it in with a single atomic RENAME TABLE,
+// so the table is never seen empty or half built. The previous generation is kept as <table>_old. The insert
+// statement is formatted with the name of the shadow table. If key is set, each row keeps the id of the existing
+// row with the same key so that references to it remain valid, and new rows get ids after every existing one.
+func (c *cardRepository) rebuildTable(ctx context.Context, table, key, insert string) error {
+	newTable := table + "_new"
+
+	statements := []string{
+		"DROP TABLE IF EXISTS " + newTable,
+		fmt.Sprintf("CREATE TABLE %s LIKE %s", newTable, table),
+	}
+
+	for _, statement := range statements {
+		_, err := c.db.ExecContext(ctx, statement)
+		if err != nil {
+			return &StatementError{statement, err}
+		}
+	}
+
+	if key != "" {
+		var nextID int64
+		err := c.db.GetContext(ctx, &nextID, fmt.Sprintf("SELECT COALESCE(MAX(id), 0) + 1 FROM %s", table))
+		if err != nil {
+			return &StatementError{"SELECT FROM " + table, err}
+		}
+
+		_, err = c.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s AUTO_INCREMENT = %d", newTable, nextID))
+		if err != nil {
+			return &StatementError{"ALTER TABLE " + newTable, err}
+		}
+	}
+
+	_, err := c.db.ExecContext(ctx, fmt.Sprintf(insert, newTable))
+	if err != nil {
+		return &StatementError{"INSERT INTO " + newTable, err}
+	}
+
+	if key != "" {
+		// Every new id is above the existing ones, so reusing the existing ids can't collide
+		_, err = c.db.ExecContext(ctx, fmt.Sprintf(`UPDATE %[1]s n
+			INNER JOIN %[2]s t ON t.%[3]s = n.%[3]s
+			SET n.id = t.id
+		`, newTable, table, key))
+		if err != nil {
+			return &StatementError{"UPDATE " + newTable, err}
+		}
+	}
+
+	return c.swapTable(ctx, table)
+}
+
+// swapTable swaps the <table>_new shadow table in with a single atomic RENAME TABLE, keeping the replaced table as
+// <table>_old.
+func (c *cardRepository) swapTable(ctx context.Context, table string) error {
+	newTable := table + "_new"
+	oldTable := table + "_old"
+
+	statements := []string{
+		"DROP TABLE IF EXISTS " + oldTable,
+		fmt.Sprintf("RENAME TABLE %s TO %s, %s TO %s", table, oldTable, newTable, table),
+	}
+
+	for _, statement := range statements {
+		_, err := c.db.ExecContext(ctx, statement)
+		if err != nil {
+			return &StatementError{statement, err}
+		}
+	}
+
+	return nil
+}
+
+// RestoreDerivedTables swaps the previous generation of every derived table back in, with a single atomic
+// RENAME TABLE, and returns the tables restored. The replaced generation is kept, so restoring again undoes it.
+func (c *cardRepository) RestoreDerivedTables(ctx context.Context) ([]string, error) {
+	restored := []string{}
+	renames := []string{}
+	for _, table := range derivedTables {
+		var count int
+		err := c.db.GetContext(ctx, &count, `SELECT COUNT(*)
+			FROM information_schema.tables t
+			WHERE t.table_schema = DATABASE()
+			AND t.table_name = ?
+		`,
+			table+"_old",
+		)
+		if err != nil {
+			return []string{}, &StatementError{"SELECT FROM information_schema.tables", err}
+		}
+
+		if count == 0 {
+			continue
+		}
+
+		restored = append(restored, table)
+		renames = append(renames, fmt.Sprintf("%[1]s TO %[1]s_swap, %[1]s_old TO %[1]s, %[1]s_swap TO %[1]s_old", table))
+	}
+
+	if len(renames) == 0 {
+		return restored, nil
+	}
+
+	_, err := c.db.ExecContext(ctx, "RENAME TABLE "+strings.Join(renames, ", "))
+	if err != nil {
+		return []string{}, &StatementError{"RENAME TABLE", err}
+	}
+
+	return restored, nil
+}
@@ -0,0 +1,262 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DeleteSeenItems removes the items recorded as seen for the specified bulk data type.
+func (b *batchRepository) DeleteSeenItems(ctx context.Context, bulkType string) error {
+	_, err := b.db.ExecContext(ctx, `DELETE FROM batch_seen_items
+		WHERE bulk_type = ?
+	`,
+		bulkType,
+	)
+
+	return err
+}
+
+// InsertSeenItems records the provided item keys as seen for the specified bulk data type, and whether the items
+// were excluded by the inclusion rules.
+func (b *batchRepository) InsertSeenItems(ctx context.Context, bulkType string, keys []string, excluded bool) error {
+	rows := make([][]interface{}, 0, len(keys))
+	for _, key := range keys {
+		rows = append(rows, []interface{}{bulkType, key, excluded})
+	}
+
+	return execBulkInsertTx(ctx, b.db, "INSERT IGNORE INTO batch_seen_items", []string{"bulk_type", "item_key", "excluded"}, "", rows)
+}
+
+// The key identifying each row of a table t in batch_seen_items
+const (
+	cardItemKey       = "t.scryfall_id"
+	rulingItemKey     = "CONCAT(t.oracle_id, ':', t.comment_hash)"
+	oracleCardItemKey = "t.oracle_id"
+)
+
+// RemoveUnseenCards marks every card that was not seen in the current run of the specified bulk data type as removed,
+// and restores any removed card that was seen again. It returns the number of cards marked as removed.
+func (c *cardRepository) RemoveUnseenCards(ctx context.Context, bulkType string, maxPercent int) (int64, error) {
+	return c.removeUnseen(ctx, "cards", cardItemKey, recordChangedCardsQuery, bulkType, maxPercent)
+}
+
+// RemoveUnseenRulings marks every ruling that was not seen in the current run of the specified bulk data type as
+// removed, and restores any removed ruling that was seen again. It returns the number of rulings marked as removed.
+func (c *cardRepository) RemoveUnseenRulings(ctx context.Context, bulkType string, maxPercent int) (int64, error) {
+	return c.removeUnseen(ctx, "card_rulings", rulingItemKey, recordChangedRulingQuery, bulkType, maxPercent)
+}
+
+// RemoveUnseenOracleCards marks every oracle card that was not seen in the current run of the specified bulk data
+// type as removed, and restores any removed oracle card that was seen again. It returns the number of oracle cards
+// marked as removed.
+func (c *cardRepository) RemoveUnseenOracleCards(ctx context.Context, bulkType string, maxPercent int) (int64, error) {
+	return c.removeUnseen(ctx, "oracle_cards", oracleCardItemKey, "", bulkType, maxPercent)
+}
+
+// RemoveUnseenCardArtwork marks every illustration whose card was not seen in the current run of the specified bulk
+// data type as removed, and restores any removed illustration whose card was seen again. It returns the number of
+// illustrations marked as removed.
+func (c *cardRepository) RemoveUnseenCardArtwork(ctx context.Context, bulkType string, maxPercent int) (int64, error) {
+	return c.removeUnseen(ctx, "card_artwork", cardItemKey, "", bulkType, maxPercent)
+}
+
+// RemoveUnseenCardPrints marks every printing that was not seen in the current run of the specified bulk data type
+// as removed, and restores any removed printing that was seen again. It returns the number of printings marked as
+// removed.
+func (c *cardRepository) RemoveUnseenCardPrints(ctx context.Context, bulkType string, maxPercent int) (int64, error) {
+	return c.removeUnseen(ctx, "card_prints", cardItemKey, "", bulkType, maxPercent)
+}
+
+// RestoreCards restores the removed cards with the provided scryfall IDs, e.g. once a quarantined card is replayed.
+func (c *cardRepository) RestoreCards(ctx context.Context, scryfallIDs []string) error {
+	return c.restoreRemoved(ctx, "cards", cardItemKey, recordChangedCardsQuery, scryfallIDs)
+}
+
+// RestoreRulings restores the removed rulings with the provided keys, e.g. once a quarantined ruling is replayed.
+func (c *cardRepository) RestoreRulings(ctx context.Context, keys []string) error {
+	return c.restoreRemoved(ctx, "card_rulings", rulingItemKey, recordChangedRulingQuery, keys)
+}
+
+// Reasons recorded in removed_reason for rows marked as removed
+const (
+	removedMissing  = "missing"
+	removedExcluded = "excluded"
+)
+
+// RestoreOracleCards restores the removed oracle cards with the provided oracle IDs, e.g. once a quarantined oracle
+// card is replayed.
+func (c *cardRepository) RestoreOracleCards(ctx context.Context, oracleIDs []string) error {
+	return c.restoreRemoved(ctx, "oracle_cards", oracleCardItemKey, "", oracleIDs)
+}
+
+// RestoreCardArtwork restores the removed illustrations of the cards with the provided scryfall IDs, e.g. once a
+// quarantined card is replayed.
+func (c *cardRepository) RestoreCardArtwork(ctx context.Context, scryfallIDs []string) error {
+	return c.restoreRemoved(ctx, "card_artwork", cardItemKey, "", scryfallIDs)
+}
+
+// RestoreCardPrints restores the removed printings with the provided scryfall IDs, e.g. once a quarantined card is
+// replayed.
+func (c *cardRepository) RestoreCardPrints(ctx context.Context, scryfallIDs []string) error {
+	return c.restoreRemoved(ctx, "card_prints", cardItemKey, "", scryfallIDs)
+}
+
+// removeUnseen marks the rows of a table as removed if their key was not seen in the current run, or was seen but
+// excluded by the inclusion rules. Removed rows seen again are restored first, and rows excluded by the rules are
+// removed regardless of the threshold, as the rules exclude them intentionally. The rows that are missing are only
+// removed if they are at most maxPercent of the rows, otherwise they are left in place and a
+// TombstoneThresholdError is returned along with the number of excluded rows removed. The rows removed or
+// restored are recorded as changed with the provided statement, if any, which selects from the table.
+func (c *cardRepository) removeUnseen(ctx context.Context, table, key, recordChanges, bulkType string, maxPercent int) (int64, error) {
+	seenJoin := fmt.Sprintf("LEFT JOIN batch_seen_items s ON s.bulk_type = ? AND s.item_key = %s", key)
+
+	_, err := c.updateTombstones(ctx, table, recordChanges, fmt.Sprintf(`UPDATE %s t
+		INNER JOIN batch_seen_items s ON s.bulk_type = ? AND s.item_key = %s
+		SET t.removed_at = NULL, t.removed_reason = NULL
+		WHERE t.removed_at IS NOT NULL
+		AND s.excluded = 0
+	`, table, key),
+		bulkType,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	excluded, err := c.updateTombstones(ctx, table, recordChanges, fmt.Sprintf(`UPDATE %s t
+		INNER JOIN batch_seen_items s ON s.bulk_type = ? AND s.item_key = %s
+		SET t.removed_at = NOW(), t.removed_reason = ?
+		WHERE t.removed_at IS NULL
+		AND s.excluded = 1
+	`, table, key),
+		bulkType,
+		removedExcluded,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	counts := struct {
+		Total  int64 `db:"total"`
+		Unseen int64 `db:"unseen"`
+	}{}
+	err = c.db.GetContext(ctx, &counts, fmt.Sprintf(`SELECT
+		COUNT(*) total,
+		COALESCE(SUM(s.item_key IS NULL), 0) unseen
+		FROM %s t
+		%s
+		WHERE t.removed_at IS NULL
+	`, table, seenJoin),
+		bulkType,
+	)
+	if err != nil {
+		return excluded, &StatementError{"SELECT FROM " + table, err}
+	}
+
+	if counts.Unseen*100 > int64(maxPercent)*counts.Total {
+		return excluded, &TombstoneThresholdError{table, counts.Unseen, counts.Total, maxPercent}
+	}
+
+	missing, err := c.updateTombstones(ctx, table, recordChanges, fmt.Sprintf(`UPDATE %s t
+		%s
+		SET t.removed_at = NOW(), t.removed_reason = ?
+		WHERE t.removed_at IS NULL
+		AND s.item_key IS NULL
+	`, table, seenJoin),
+		bulkType,
+		removedMissing,
+	)
+	if err != nil {
+		return excluded, err
+	}
+
+	return excluded + missing, nil
+}
+
+// restoreRemoved clears removed_at on the rows of a table with the provided keys, recording the rows restored as
+// changed with the provided statement, if any, which selects from the table.
+func (c *cardRepository) restoreRemoved(ctx context.Context, table, key, recordChanges string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	query, args, err := sqlx.In(fmt.Sprintf(`UPDATE %s t
+		SET t.removed_at = NULL, t.removed_reason = NULL
+		WHERE t.removed_at IS NOT NULL
+		AND %s IN (?)
+	`, table, key),
+		keys,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.updateTombstones(ctx, table, recordChanges, c.db.Rebind(query), args...)
+
+	return err
+}
+
+// updateTombstones runs a statement that marks rows of a table as removed or restores them, and records the rows it
+// changed with the provided statement, if any, which selects from the table, in a single transaction. It returns
+// the number of rows changed.
+func (c *cardRepository) updateTombstones(ctx context.Context, table, recordChanges, query string, args ...interface{}) (int64, error) {
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	marker, err := changeMarker(tx)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return 0, rollbackErr
+		}
+
+		return 0, err
+	}
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return 0, rollbackErr
+		}
+
+		return 0, &StatementError{"UPDATE " + table, err}
+	}
+
+	changed, err := result.RowsAffected()
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return 0, rollbackErr
+		}
+
+		return 0, err
+	}
+
+	if recordChanges != "" {
+		_, err = tx.Exec(recordChanges+`
+			WHERE changed_at >= ?
+		`,
+			marker,
+		)
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return 0, rollbackErr
+			}
+
+			return 0, &StatementError{"INSERT INTO changed_" + strings.TrimPrefix(table, "card_"), err}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return 0, rollbackErr
+		}
+
+		return 0, err
+	}
+
+	return changed, nil
+}
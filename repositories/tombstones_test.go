@@ -0,0 +1,83 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// expectTombstoneUpdate expects a transaction that runs an UPDATE of cards matching statement, changing the provided
+// number of rows, and records the changed cards.
+func expectTombstoneUpdate(mock sqlmock.Sqlmock, statement string, changed int64) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT NOW(6)")).WillReturnRows(sqlmock.NewRows([]string{"NOW(6)"}).AddRow("2024-03-29 09:12:00.000000"))
+	mock.ExpectExec(regexp.QuoteMeta(statement)).WillReturnResult(sqlmock.NewResult(0, changed))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO changed_cards")).WillReturnResult(sqlmock.NewResult(0, changed))
+	mock.ExpectCommit()
+}
+
+func TestRemoveUnseenCards(t *testing.T) {
+	tests := []struct {
+		name string
+		// restored is the number of removed cards seen again, and excluded the number excluded by the rules
+		restored      int64
+		excluded      int64
+		total         int64
+		unseen        int64
+		wantRemoved   int64
+		wantThreshold bool
+	}{
+		{"nothing to remove", 0, 0, 100, 0, 0, false},
+		{"unseen within threshold", 0, 0, 100, 5, 5, false},
+		{"unseen over threshold", 0, 0, 100, 6, 0, true},
+		{"restored while unseen over threshold", 3, 0, 100, 50, 0, true},
+		{"rules exclude most cards", 0, 60, 40, 1, 61, false},
+		{"rules exclude cards while unseen over threshold", 2, 30, 70, 20, 30, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			// Reappearing cards are restored, and cards excluded by the rules removed, whatever the threshold
+			expectTombstoneUpdate(mock, "SET t.removed_at = NULL, t.removed_reason = NULL", test.restored)
+			expectTombstoneUpdate(mock, "AND s.excluded = 1", test.excluded)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT")).WillReturnRows(sqlmock.NewRows([]string{"total", "unseen"}).AddRow(test.total, test.unseen))
+			if !test.wantThreshold {
+				expectTombstoneUpdate(mock, "AND s.item_key IS NULL", test.unseen)
+			}
+
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+			repo := NewCardRepository(logger, sqlx.NewDb(db, "mysql"))
+
+			removed, err := repo.RemoveUnseenCards(context.Background(), "default_cards", 5)
+
+			var thresholdErr *TombstoneThresholdError
+			if errors.As(err, &thresholdErr) != test.wantThreshold {
+				t.Errorf("got error %v, want threshold error %t", err, test.wantThreshold)
+			} else if err != nil && !test.wantThreshold {
+				t.Fatal(err)
+			}
+
+			if removed != test.wantRemoved {
+				t.Errorf("got %d removed, want %d", removed, test.wantRemoved)
+			}
+
+			err = mock.ExpectationsWereMet()
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
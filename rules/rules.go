@@ -0,0 +1,306 @@
+package rules
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+)
+
+// Actions a rule can take when it matches a card
+const (
+	ActionInclude = "include"
+	ActionExclude = "exclude"
+)
+
+// DefaultRuleName is reported for cards that matched no rule and fell through to the default action
+const DefaultRuleName = "default"
+
+//go:embed default.json
+var defaultConfig []byte
+
+// Config holds the rule set used for each bulk data type, keyed by bulk data type
+type Config map[string]*RuleSet
+
+// RuleSet is an ordered list of rules. The first rule that matches a card decides whether it is
+// included, and cards that match no rule are handled by the default action.
+type RuleSet struct {
+	Default string `json:"default"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Rule includes or excludes a card when every one of its conditions matches
+type Rule struct {
+	Name   string      `json:"name"`
+	Action string      `json:"action"`
+	When   []Condition `json:"when"`
+}
+
+// Condition matches a single ScryfallCard field, referenced by its JSON name. Fields holding a list
+// (e.g. games) match In and Matches when any value matches, and NotIn and NotMatches when no value does.
+type Condition struct {
+	Field      string   `json:"field"`
+	In         []string `json:"in"`
+	NotIn      []string `json:"not_in"`
+	Matches    string   `json:"matches"`
+	NotMatches string   `json:"not_matches"`
+
+	fieldIndex int
+	matches    *regexp.Regexp
+	notMatches *regexp.Regexp
+}
+
+// Decision is the outcome of evaluating a card against a rule set
+type Decision struct {
+	Included bool
+	Rule     string
+}
+
+// cardFields maps the JSON name of each ScryfallCard field to its index
+var cardFields = func() map[string]int {
+	fields := make(map[string]int)
+	cardType := reflect.TypeOf(models.ScryfallCard{})
+	for i := 0; i < cardType.NumField(); i++ {
+		name := strings.Split(cardType.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+
+	return fields
+}()
+
+// Default returns the built in rules, which reproduce the filters the batch has always applied.
+func Default() (Config, error) {
+	return parse(defaultConfig)
+}
+
+// Load reads rules from the JSON file at the provided path. Bulk data types missing from the file
+// keep their built in rules.
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	overrides, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	for bulkType, ruleSet := range overrides {
+		config[bulkType] = ruleSet
+	}
+
+	return config, nil
+}
+
+// parse decodes and validates a rules config.
+func parse(data []byte) (Config, error) {
+	var config Config
+	err := json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	for bulkType, ruleSet := range config {
+		err = ruleSet.compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid rules for %s: %w", bulkType, err)
+		}
+	}
+
+	return config, nil
+}
+
+// compile validates the rule set and prepares its conditions for evaluation.
+func (r *RuleSet) compile() error {
+	if r.Default == "" {
+		r.Default = ActionInclude
+	}
+
+	if !isAction(r.Default) {
+		return fmt.Errorf("invalid default action %q", r.Default)
+	}
+
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		if !isAction(rule.Action) {
+			return fmt.Errorf("%s: invalid action %q", rule.Name, rule.Action)
+		}
+
+		if len(rule.When) == 0 {
+			return fmt.Errorf("%s: no conditions", rule.Name)
+		}
+
+		for j := range rule.When {
+			err := rule.When[j].compile()
+			if err != nil {
+				return fmt.Errorf("%s: %w", rule.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// compile resolves the condition's field and compiles its regular expressions.
+func (c *Condition) compile() error {
+	index, ok := cardFields[c.Field]
+	if !ok {
+		return fmt.Errorf("unknown card field %q", c.Field)
+	}
+	c.fieldIndex = index
+
+	if !isMatchable(reflect.TypeOf(models.ScryfallCard{}).Field(index).Type) {
+		return fmt.Errorf("card field %q can't be matched, only text, number, boolean and list fields can", c.Field)
+	}
+
+	if c.In == nil && c.NotIn == nil && c.Matches == "" && c.NotMatches == "" {
+		return fmt.Errorf("condition on %s has nothing to match", c.Field)
+	}
+
+	var err error
+	if c.Matches != "" {
+		c.matches, err = regexp.Compile(c.Matches)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.NotMatches != "" {
+		c.notMatches, err = regexp.Compile(c.NotMatches)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Evaluate returns whether the provided card should be included, along with the rule that decided it.
+func (r *RuleSet) Evaluate(card models.ScryfallCard) Decision {
+	cardValue := reflect.ValueOf(card)
+	for _, rule := range r.Rules {
+		if rule.matches(cardValue) {
+			return Decision{
+				Included: rule.Action == ActionInclude,
+				Rule:     rule.Name,
+			}
+		}
+	}
+
+	return Decision{
+		Included: r.Default == ActionInclude,
+		Rule:     DefaultRuleName,
+	}
+}
+
+// matches returns whether every condition of the rule matches the card.
+func (r *Rule) matches(card reflect.Value) bool {
+	for _, condition := range r.When {
+		if !condition.matchesValues(fieldValues(card.Field(condition.fieldIndex))) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesValues returns whether the condition matches the provided field values.
+func (c *Condition) matchesValues(values []string) bool {
+	if c.In != nil && !anyValue(values, func(value string) bool { return contains(c.In, value) }) {
+		return false
+	}
+
+	if c.NotIn != nil && anyValue(values, func(value string) bool { return contains(c.NotIn, value) }) {
+		return false
+	}
+
+	if c.matches != nil && !anyValue(values, c.matches.MatchString) {
+		return false
+	}
+
+	if c.notMatches != nil && anyValue(values, c.notMatches.MatchString) {
+		return false
+	}
+
+	return true
+}
+
+// fieldValues returns the string representation of a card field, with one entry per value for list fields.
+func fieldValues(field reflect.Value) []string {
+	switch field.Kind() {
+	case reflect.String:
+		return []string{field.String()}
+	case reflect.Bool:
+		return []string{strconv.FormatBool(field.Bool())}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return []string{strconv.FormatInt(field.Int(), 10)}
+	case reflect.Float32, reflect.Float64:
+		return []string{strconv.FormatFloat(field.Float(), 'f', -1, 64)}
+	case reflect.Slice:
+		values := []string{}
+		for i := 0; i < field.Len(); i++ {
+			values = append(values, fieldValues(field.Index(i))...)
+		}
+
+		return values
+	}
+
+	return nil
+}
+
+// isMatchable returns whether fieldValues can represent a field of the provided type, i.e. whether it is a scalar
+// or a list of scalars.
+func isMatchable(fieldType reflect.Type) bool {
+	if fieldType.Kind() == reflect.Slice {
+		fieldType = fieldType.Elem()
+	}
+
+	switch fieldType.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return false
+}
+
+func anyValue(values []string, match func(string) bool) bool {
+	for _, value := range values {
+		if match(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isAction(action string) bool {
+	return action == ActionInclude || action == ActionExclude
+}
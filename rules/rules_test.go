@@ -0,0 +1,106 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+)
+
+// includedBeforeRules is the filter the batch applied to default cards before inclusion rules were configurable
+func includedBeforeRules(card models.ScryfallCard) bool {
+	validPrint := card.Lang == "en" && !card.Digital
+	validCardType := card.TypeLine != "Vanguard" && card.Layout != "art_series" && card.Layout != "planar" && card.Layout != "scheme"
+	validSetType := card.SetType != "memorabilia"
+	validFunny := card.SetType != "funny" || (card.SetType == "funny" && (strings.Contains(card.TypeLine, "Plains") ||
+		strings.Contains(card.TypeLine, "Island") ||
+		strings.Contains(card.TypeLine, "Swamp") ||
+		strings.Contains(card.TypeLine, "Mountain") ||
+		strings.Contains(card.TypeLine, "Forest")))
+
+	return validPrint && validCardType && validSetType && validFunny
+}
+
+func TestDefaultRulesMatchFilterBeforeRules(t *testing.T) {
+	config, err := Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ruleSet := config["default_cards"]
+	if ruleSet == nil {
+		t.Fatal("no default_cards rules")
+	}
+
+	langs := []string{"en", "ja", ""}
+	digitals := []bool{false, true}
+	typeLines := []string{
+		"Creature — Goblin",
+		"Vanguard",
+		"Legendary Vanguard",
+		"Basic Land — Plains",
+		"Basic Land — Island",
+		"Snow Basic Land — Swamp",
+		"Basic Land — Mountain",
+		"Basic Land — Forest",
+		"Land — Forest Plains",
+		"Instant",
+		"",
+	}
+	layouts := []string{"normal", "transform", "art_series", "planar", "scheme", "token"}
+	setTypes := []string{"expansion", "core", "memorabilia", "funny", "promo"}
+
+	for _, lang := range langs {
+		for _, digital := range digitals {
+			for _, typeLine := range typeLines {
+				for _, layout := range layouts {
+					for _, setType := range setTypes {
+						card := models.ScryfallCard{
+							Lang:     lang,
+							Digital:  digital,
+							TypeLine: typeLine,
+							Layout:   layout,
+							SetType:  setType,
+						}
+
+						want := includedBeforeRules(card)
+						decision := ruleSet.Evaluate(card)
+						if decision.Included != want {
+							t.Errorf("lang=%q digital=%t type_line=%q layout=%q set_type=%q: got included=%t by rule %q, want %t",
+								lang, digital, typeLine, layout, setType, decision.Included, decision.Rule, want)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestConditionCompile(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition Condition
+		wantErr   bool
+	}{
+		{"text field", Condition{Field: "lang", In: []string{"en"}}, false},
+		{"boolean field", Condition{Field: "digital", In: []string{"true"}}, false},
+		{"number field", Condition{Field: "cmc", In: []string{"3"}}, false},
+		{"list field", Condition{Field: "games", NotIn: []string{"paper"}}, false},
+		{"unknown field", Condition{Field: "nope", In: []string{"x"}}, true},
+		{"nothing to match", Condition{Field: "lang"}, true},
+		{"invalid regular expression", Condition{Field: "name", Matches: "("}, true},
+		{"struct field", Condition{Field: "legalities", In: []string{"legal"}}, true},
+		{"prices", Condition{Field: "prices", NotIn: []string{"0"}}, true},
+		{"image uris", Condition{Field: "image_uris", Matches: "png"}, true},
+		{"list of structs", Condition{Field: "card_faces", Matches: "x"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.condition.compile()
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
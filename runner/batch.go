@@ -1,253 +1,725 @@
 package runner
 
 import (
-	"encoding/json"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"strings"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/BrandonWade/blackblade-batch/models"
+	"github.com/BrandonWade/blackblade-batch/rules"
 	"github.com/BrandonWade/blackblade-batch/services"
 	"github.com/sirupsen/logrus"
 )
 
 // BatchRunner interface for working with a batchRunner
 type BatchRunner interface {
-	Run()
+	Run(ctx context.Context) error
+	ReplayFailures(ctx context.Context) error
+	Explain(ctx context.Context, query string) error
+	RestoreDerivedTables(ctx context.Context) error
+	Runs(ctx context.Context, id string) error
+}
+
+// Bulk data types as reported by ScryfallBulkData.Type
+const (
+	defaultCardsBulkType  = "default_cards"
+	rulingsBulkType       = "rulings"
+	oracleCardsBulkType   = "oracle_cards"
+	uniqueArtworkBulkType = "unique_artwork"
+	allCardsBulkType      = "all_cards"
+)
+
+// DefaultBulkTypes are the bulk data types processed when none are configured
+var DefaultBulkTypes = []string{
+	defaultCardsBulkType,
+	rulingsBulkType,
+}
+
+// IsSupportedBulkType returns whether the batch is able to process the provided bulk data type
+func IsSupportedBulkType(bulkType string) bool {
+	switch bulkType {
+	case defaultCardsBulkType, rulingsBulkType, oracleCardsBulkType, uniqueArtworkBulkType, allCardsBulkType:
+		return true
+	}
+
+	return false
+}
+
+// Ingest modes for writing cards to the database
+const (
+	// IngestModeUpsert upserts each batch of cards directly into the cards tables
+	IngestModeUpsert = "upsert"
+	// IngestModeStaging bulk loads each batch of cards into staging tables and merges them into the cards tables at the end
+	IngestModeStaging = "staging"
+)
+
+// Config options for controlling the behaviour of a batchRunner
+type Config struct {
+	// Force processes bulk data files even if they have not changed since the last successful run
+	Force bool
+
+	// DataDir is the directory bulk data files are downloaded to
+	DataDir string
+
+	// IngestMode is how cards are written to the database, either IngestModeUpsert or IngestModeStaging
+	IngestMode string
+
+	// Parallelism is the number of workers writing batches to the database concurrently
+	Parallelism int
+
+	// BatchSize is the number of cards or rulings written to the database together
+	BatchSize int
+
+	// BulkTypes are the bulk data types to process, in order, as reported by ScryfallBulkData.Type
+	BulkTypes []string
+
+	// Localizations stores the printed text of non-English cards when processing the all-cards bulk data type
+	Localizations bool
+
+	// Rules decide which cards are included for each bulk data type
+	Rules rules.Config
+
+	// TombstoneMaxPercent is the largest percentage of cards or rulings that can be marked as removed in one run
+	TombstoneMaxPercent int
+
+	// MaxCountDropPercent is the largest percentage by which the number of included items in a bulk data file can
+	// drop since the last successful run before the file is considered broken
+	MaxCountDropPercent int
+
+	// MaxMissingFieldPercent is the largest percentage of included items that can be missing a required field
+	// before the bulk data file is considered broken
+	MaxMissingFieldPercent int
+
+	// SkipSanityChecks processes bulk data files that fail the sanity checks, logging a warning instead
+	SkipSanityChecks bool
+
+	// FullRebuild recalculates the derived data of every card and ruling, instead of only those that changed
+	FullRebuild bool
 }
 
 type batchRunner struct {
-	logger      *logrus.Logger
-	cardService services.CardService
+	logger       *logrus.Logger
+	cardService  services.CardService
+	batchService services.BatchService
+	config       Config
 }
 
 // NewBatchRunner create a new BatchRunner instance
-func NewBatchRunner(logger *logrus.Logger, cardService services.CardService) BatchRunner {
+func NewBatchRunner(logger *logrus.Logger, cardService services.CardService, batchService services.BatchService, config Config) BatchRunner {
 	return &batchRunner{
 		logger,
 		cardService,
+		batchService,
+		config,
 	}
 }
 
-// Run download data from the Scryfall API and process it
-func (b *batchRunner) Run() {
+// Run download data from the Scryfall API and process it. The error returned for a failed stage is a *StageError.
+// If ctx is cancelled, the batch being written is rolled back and the run stops with an error matching
+// context.Canceled, to be resumed from its checkpoint by the next run.
+func (b *batchRunner) Run(ctx context.Context) error {
 	b.logger.Println("Batch starting...")
 	start := time.Now()
 
-	err := b.processCards()
+	// The run is recorded even once ctx is cancelled, so that a cancelled run isn't left running
+	recordCtx := detached(ctx)
+	runID, err := b.batchService.StartRun(recordCtx)
 	if err != nil {
-		return
+		b.logger.Errorf("error recording start of run: %s", err.Error())
+		return err
 	}
 
-	err = b.processRulings()
+	var runErr error
+	for _, ingestor := range b.ingestors() {
+		// Stop between bulk data files if the run was cancelled
+		if ctx.Err() != nil {
+			runErr = ctx.Err()
+			break
+		}
+
+		runErr = ingestor.Ingest(ctx)
+		if runErr != nil {
+			runErr = cancelledError(ctx, runErr)
+		}
+
+		b.recordRunBulkData(recordCtx, runID, ingestor, runErr)
+		if runErr != nil {
+			break
+		}
+	}
+
+	err = b.batchService.FinishRun(recordCtx, runID, runErr)
 	if err != nil {
-		return
+		b.logger.Errorf("error recording end of run %d: %s", runID, err.Error())
+	}
+
+	if errors.Is(runErr, context.Canceled) {
+		b.logger.Warnf("Batch cancelled after %s: %s", time.Since(start), runErr.Error())
+		return runErr
+	} else if runErr != nil {
+		b.logger.Errorf("Batch failed after %s: %s", time.Since(start), runErr.Error())
+		return runErr
 	}
 
 	elapsed := time.Since(start)
 	b.logger.Printf("Batch completed in %s.", elapsed)
+
+	return nil
 }
 
-func (b *batchRunner) processCards() error {
-	b.logger.Println("Downloading default-cards bulk data file...")
-	defaultCards, err := b.cardService.GetDefaultCards()
-	if err != nil {
-		b.logger.Errorf("error fetching default cards from api: %s", err.Error())
-		return err
+// ingestors returns an ingestor for each configured bulk data type, in the order they are processed.
+func (b *batchRunner) ingestors() []bulkDataIngestor {
+	ingestors := []bulkDataIngestor{}
+	for _, bulkType := range b.config.BulkTypes {
+		ingestors = append(ingestors, b.ingestor(bulkType))
 	}
 
-	if (defaultCards == models.ScryfallBulkData{}) {
-		err = errors.New("default cards not found")
-		b.logger.Errorf(err.Error())
-		return err
-	}
+	return ingestors
+}
 
-	filepath := fmt.Sprintf("defaultcards-%v.json", int32(time.Now().Unix()))
-	err = b.cardService.DownloadDefaultCardData(defaultCards.DownloadURI, filepath)
-	if err != nil {
-		b.logger.Fatalf("error downloading default cards data from api: %s", err.Error())
-		return err
+// ingestor returns the ingestor for the provided bulk data type, or nil if the type is not supported.
+func (b *batchRunner) ingestor(bulkType string) bulkDataIngestor {
+	switch bulkType {
+	case defaultCardsBulkType:
+		return b.defaultCardsIngestor()
+	case rulingsBulkType:
+		return b.rulingsIngestor()
+	case oracleCardsBulkType:
+		return b.oracleCardsIngestor()
+	case uniqueArtworkBulkType:
+		return b.uniqueArtworkIngestor()
+	case allCardsBulkType:
+		return b.allCardsIngestor()
 	}
 
-	b.logger.Println("Processing default-cards bulk data file...")
+	return nil
+}
 
-	file, err := os.Open(filepath)
-	if err != nil {
-		b.logger.Fatalf("error opening default cards data file: %s", err.Error())
-		return err
+func (b *batchRunner) defaultCardsIngestor() *BulkIngestor[models.ScryfallCard] {
+	stats := &cardWriteStats{}
+	ingestor := &BulkIngestor[models.ScryfallCard]{
+		Type:       defaultCardsBulkType,
+		FilePrefix: "defaultcards",
+		ItemID: func(card models.ScryfallCard) string {
+			return card.ID
+		},
+		ItemName: func(card models.ScryfallCard) string {
+			return card.Name
+		},
+		Include:  b.includeCard(defaultCardsBulkType),
+		Validate: validateCard,
+		ItemKey: func(card models.ScryfallCard) string {
+			return card.ID
+		},
+		Sweep:   b.cardService.RemoveUnseenCards,
+		Restore: b.cardService.RestoreCards,
+		// Only the legalities observed in this run are published
+		Reset: b.cardService.ResetStagedLegalities,
+		Write: func(ctx context.Context, cards []models.ScryfallCard) error {
+			return stats.add(b.cardService.UpsertCards(ctx, cards))
+		},
+		WriteCounts: func() (int64, int64, int64) {
+			total := stats.total()
+			return total.Inserted, total.Changed, total.Unchanged
+		},
+		Generate: func(ctx context.Context) error {
+			return b.generateCardData(ctx, b.config.FullRebuild)
+		},
+		Finish: func(ctx context.Context, data models.ScryfallBulkData) error {
+			total := stats.total()
+			b.logger.Printf("Inserted %d card(s), updated %d changed card(s) and skipped %d unchanged card(s).", total.Inserted, total.Changed, total.Unchanged)
+			b.logger.Printf("Removed %d stale multiverse ID(s), %d frame effect(s) and %d face(s).", total.Removed.MultiverseIDs, total.Removed.FrameEffects, total.Removed.Faces)
+			return b.recordPriceSnapshot(ctx, data)
+		},
+		runner: b,
 	}
 
-	dec := json.NewDecoder(file)
-	// dec.DisallowUnknownFields()
+	if b.config.IngestMode == IngestModeStaging {
+		ingestor.Stage = b.cardService.StageCards
+		ingestor.ResetStaged = b.cardService.ResetStagedCards
+		ingestor.MergeStaged = func(ctx context.Context) error {
+			return stats.add(b.cardService.MergeStagedCards(ctx))
+		}
+	}
 
-	// read opening bracket
-	_, err = dec.Token()
-	if err != nil {
-		b.logger.Errorf("error parsing card data: %s", err.Error())
-		return err
+	return ingestor
+}
+
+func (b *batchRunner) rulingsIngestor() *BulkIngestor[models.ScryfallRuling] {
+	var inserted int64
+	return &BulkIngestor[models.ScryfallRuling]{
+		Type:       rulingsBulkType,
+		FilePrefix: "rulings",
+		ItemID: func(ruling models.ScryfallRuling) string {
+			return ruling.OracleID
+		},
+		Validate: validateRuling,
+		// Matches the key of card_rulings rows, whose comment_hash is the MD5 hash of the comment
+		ItemKey: func(ruling models.ScryfallRuling) string {
+			return fmt.Sprintf("%s:%x", ruling.OracleID, md5.Sum([]byte(ruling.Comment)))
+		},
+		Sweep:   b.cardService.RemoveUnseenRulings,
+		Restore: b.cardService.RestoreRulings,
+		Write: func(ctx context.Context, rulings []models.ScryfallRuling) error {
+			count, err := b.cardService.InsertRulings(ctx, rulings)
+			atomic.AddInt64(&inserted, count)
+			return err
+		},
+		// Rulings are never updated, only inserted or marked as removed
+		WriteCounts: func() (int64, int64, int64) {
+			return atomic.LoadInt64(&inserted), 0, 0
+		},
+		Generate: func(ctx context.Context) error {
+			return b.generateRulingData(ctx, b.config.FullRebuild)
+		},
+		runner: b,
 	}
+}
 
-	cards := []models.ScryfallCard{}
-	for dec.More() {
-		var card models.ScryfallCard
-		err = dec.Decode(&card)
-		if err != nil {
-			b.logger.Errorf("error decoding card: %s", err.Error())
+func (b *batchRunner) oracleCardsIngestor() *BulkIngestor[models.ScryfallCard] {
+	return &BulkIngestor[models.ScryfallCard]{
+		Type:       oracleCardsBulkType,
+		FilePrefix: "oraclecards",
+		ItemID: func(card models.ScryfallCard) string {
+			return card.ID
+		},
+		ItemName: func(card models.ScryfallCard) string {
+			return card.Name
+		},
+		Include:  b.includeCard(oracleCardsBulkType),
+		Validate: validateCard,
+		ItemKey: func(card models.ScryfallCard) string {
+			return card.OracleID
+		},
+		Sweep:   b.cardService.RemoveUnseenOracleCards,
+		Restore: b.cardService.RestoreOracleCards,
+		Write:   b.cardService.UpsertOracleCards,
+		Generate: func(ctx context.Context) error {
+			return b.generateOracleCardData(ctx, b.config.FullRebuild)
+		},
+		runner: b,
+	}
+}
+
+func (b *batchRunner) uniqueArtworkIngestor() *BulkIngestor[models.ScryfallCard] {
+	return &BulkIngestor[models.ScryfallCard]{
+		Type:       uniqueArtworkBulkType,
+		FilePrefix: "uniqueartwork",
+		ItemID: func(card models.ScryfallCard) string {
+			return card.ID
+		},
+		ItemName: func(card models.ScryfallCard) string {
+			return card.Name
+		},
+		Include:  b.includeCard(uniqueArtworkBulkType),
+		Validate: validateCard,
+		ItemKey: func(card models.ScryfallCard) string {
+			return card.ID
+		},
+		Sweep:   b.cardService.RemoveUnseenCardArtwork,
+		Restore: b.cardService.RestoreCardArtwork,
+		Write:   b.cardService.UpsertCardArtwork,
+		Generate: func(ctx context.Context) error {
+			b.logger.Println("Calculating card_artwork_list table...")
+			return b.cardService.GenerateCardArtworkJSON(ctx)
+		},
+		runner: b,
+	}
+}
 
-			if err.Error() == "not at beginning of value" {
-				b.logger.Fatalf("bulk data file contents in unexpected format - is the scryfall bulk data api broken?")
+func (b *batchRunner) allCardsIngestor() *BulkIngestor[models.ScryfallCard] {
+	return &BulkIngestor[models.ScryfallCard]{
+		Type:       allCardsBulkType,
+		FilePrefix: "allcards",
+		ItemID: func(card models.ScryfallCard) string {
+			return card.ID
+		},
+		ItemName: func(card models.ScryfallCard) string {
+			return card.Name
+		},
+		Include:  b.includeCard(allCardsBulkType),
+		Validate: validateCard,
+		ItemKey: func(card models.ScryfallCard) string {
+			return card.ID
+		},
+		Sweep:   b.cardService.RemoveUnseenCardPrints,
+		Restore: b.cardService.RestoreCardPrints,
+		Write:   b.writeCardPrints,
+		Generate: func(ctx context.Context) error {
+			b.logger.Println("Calculating card_languages_list table...")
+			err := b.cardService.GenerateCardLanguagesJSON(ctx)
+			if err != nil || !b.config.Localizations {
+				return err
 			}
-		}
 
-		validPrint := card.Lang == "en" && !card.Digital
-		validCardType := card.TypeLine != "Vanguard" && card.Layout != "art_series" && card.Layout != "planar" && card.Layout != "scheme"
-		validSetType := card.SetType != "memorabilia"
-		validFunny := card.SetType != "funny" || (card.SetType == "funny" && (strings.Contains(card.TypeLine, "Plains") ||
-			strings.Contains(card.TypeLine, "Island") ||
-			strings.Contains(card.TypeLine, "Swamp") ||
-			strings.Contains(card.TypeLine, "Mountain") ||
-			strings.Contains(card.TypeLine, "Forest")))
-
-		if validPrint && validCardType && validSetType && validFunny {
-			cards = append(cards, card)
-		}
+			// The localized text is included in the derived card JSON, so it is recalculated for the cards whose
+			// localizations changed
+			return b.generateLocalizedCardData(ctx, b.config.FullRebuild)
+		},
+		runner: b,
+	}
+}
 
-		if len(cards) == 100 {
-			err = b.cardService.UpsertCards(cards)
-			if err != nil {
-				b.logger.Errorf("error upserting cards: %s", err.Error())
-			}
+// validateCard returns the required fields that are empty on the provided card.
+func validateCard(card models.ScryfallCard) []string {
+	missing := []string{}
+	if card.ID == "" {
+		missing = append(missing, "id")
+	}
 
-			cards = []models.ScryfallCard{}
-		}
+	if card.OracleID == "" {
+		missing = append(missing, "oracle_id")
 	}
 
-	if len(cards) > 0 {
-		err = b.cardService.UpsertCards(cards)
-		if err != nil {
-			b.logger.Errorf("error upserting cards: %s", err.Error())
-			return err
-		}
+	if card.Name == "" {
+		missing = append(missing, "name")
 	}
 
-	// read closing bracket
-	_, err = dec.Token()
+	if card.Set == "" {
+		missing = append(missing, "set")
+	}
+
+	return missing
+}
+
+// validateRuling returns the required fields that are empty on the provided ruling.
+func validateRuling(ruling models.ScryfallRuling) []string {
+	missing := []string{}
+	if ruling.OracleID == "" {
+		missing = append(missing, "oracle_id")
+	}
+
+	if ruling.Comment == "" {
+		missing = append(missing, "comment")
+	}
+
+	return missing
+}
+
+// cardWriteStats totals the cards written and the stale child rows removed from cards by concurrent writers during a run
+type cardWriteStats struct {
+	mu    sync.Mutex
+	stats models.CardWriteStats
+}
+
+// add adds the outcome of a write to the total, passing through the write's error.
+func (s *cardWriteStats) add(stats models.CardWriteStats, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Inserted += stats.Inserted
+	s.stats.Changed += stats.Changed
+	s.stats.Unchanged += stats.Unchanged
+	s.stats.Removed.MultiverseIDs += stats.Removed.MultiverseIDs
+	s.stats.Removed.FrameEffects += stats.Removed.FrameEffects
+	s.stats.Removed.Faces += stats.Removed.Faces
+
+	return err
+}
+
+// total returns the totals so far.
+func (s *cardWriteStats) total() models.CardWriteStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats
+}
+
+// writeCardPrints writes every printing of the provided cards, along with their localized text if enabled.
+func (b *batchRunner) writeCardPrints(ctx context.Context, cards []models.ScryfallCard) error {
+	err := b.cardService.UpsertCardPrints(ctx, cards)
 	if err != nil {
-		b.logger.Errorf("error parsing card data: %s", err.Error())
 		return err
 	}
 
+	if !b.config.Localizations {
+		return nil
+	}
+
+	return b.cardService.UpsertCardLocalizations(ctx, cards)
+}
+
+// includeCard returns a function deciding whether a card of the provided bulk data type should be
+// shown on the site, according to the configured rules. Every card is included if no rules are configured.
+func (b *batchRunner) includeCard(bulkType string) func(models.ScryfallCard) rules.Decision {
+	ruleSet, ok := b.config.Rules[bulkType]
+	if !ok {
+		return nil
+	}
+
+	return ruleSet.Evaluate
+}
+
+// recordPriceSnapshot appends the current card prices to the price history and recalculates the price trends.
+func (b *batchRunner) recordPriceSnapshot(ctx context.Context, data models.ScryfallBulkData) error {
+	b.logger.Println("Recording card_price_history snapshot...")
+	err := b.cardService.RecordPriceSnapshot(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	b.logger.Println("Calculating price_trends tables...")
+	return b.cardService.GeneratePriceTrends(ctx)
+}
+
+// generateCardData calculates the data derived from the cards in the database. Unless full is set, only the
+// data derived from the changed cards is recalculated.
+func (b *batchRunner) generateCardData(ctx context.Context, full bool) error {
 	b.logger.Println("Calculating cards.faces_json column values...")
-	err = b.cardService.GenerateCardFacesJSON()
+	err := b.cardService.GenerateCardFacesJSON(ctx, full)
 	if err != nil {
 		b.logger.Errorf("error generating cards.faces_json values: %s", err.Error())
 		return err
 	}
 
 	b.logger.Println("Calculating card_sets_list table...")
-	err = b.cardService.GenerateCardSetsJSON()
+	err = b.cardService.GenerateCardSetsJSON(ctx, full)
 	if err != nil {
 		b.logger.Errorf("error generating card_sets_list table: %s", err.Error())
 		return err
 	}
 
 	b.logger.Println("Calculating sets table...")
-	err = b.cardService.GenerateSets()
+	err = b.cardService.GenerateSets(ctx)
 	if err != nil {
 		b.logger.Errorf("error generating sets table: %s", err.Error())
 		return err
 	}
 
+	b.logger.Println("Calculating card_legalities_list table...")
+	changes, err := b.cardService.GenerateLegalities(ctx)
+	if err != nil {
+		b.logger.Errorf("error generating card_legalities_list table: %s", err.Error())
+		return err
+	}
+
+	if changes > 0 {
+		b.logger.Printf("Recorded %d legality change(s).", changes)
+	}
+
+	err = b.cardService.ClearChangedCards(ctx)
+	if err != nil {
+		b.logger.Errorf("error clearing changed cards: %s", err.Error())
+		return err
+	}
+
 	return nil
 }
 
-// When go gets generics, it might be possible to de-dupe a lot of this code...
-func (b *batchRunner) processRulings() error {
-	b.logger.Println("Downloading rulings bulk data file...")
-	rulingsData, err := b.cardService.GetRulings()
+// generateLocalizedCardData calculates the data derived from the cards that includes their localized text. Unless
+// full is set, only the data derived from the changed cards is recalculated.
+func (b *batchRunner) generateLocalizedCardData(ctx context.Context, full bool) error {
+	b.logger.Println("Calculating cards.faces_json column values...")
+	err := b.cardService.GenerateCardFacesJSON(ctx, full)
 	if err != nil {
-		b.logger.Errorf("error fetching card rulings from api: %s", err.Error())
+		b.logger.Errorf("error generating cards.faces_json values: %s", err.Error())
 		return err
 	}
 
-	if (rulingsData == models.ScryfallBulkData{}) {
-		err = errors.New("rulings not found")
-		b.logger.Errorf(err.Error())
+	// The faces JSON of each printing is included in card_sets_list
+	b.logger.Println("Calculating card_sets_list table...")
+	err = b.cardService.GenerateCardSetsJSON(ctx, full)
+	if err != nil {
+		b.logger.Errorf("error generating card_sets_list table: %s", err.Error())
 		return err
 	}
 
-	filepath := fmt.Sprintf("rulings-%v.json", int32(time.Now().Unix()))
-	err = b.cardService.DownloadRulingsData(rulingsData.DownloadURI, filepath)
+	err = b.cardService.ClearChangedCards(ctx)
 	if err != nil {
-		b.logger.Fatalf("error downloading card rulings data from api: %s", err.Error())
+		b.logger.Errorf("error clearing changed cards: %s", err.Error())
 		return err
 	}
 
-	b.logger.Println("Processing rulings bulk data file...")
+	return nil
+}
 
-	file, err := os.Open(filepath)
+// generateRulingData calculates the data derived from the rulings in the database. Unless full is set, only the
+// data derived from the changed rulings is recalculated.
+func (b *batchRunner) generateRulingData(ctx context.Context, full bool) error {
+	b.logger.Println("Calculating card_rulings_list table...")
+	err := b.cardService.GenerateRulingsJSON(ctx, full)
 	if err != nil {
-		b.logger.Fatalf("error opening rulings data file: %s", err.Error())
+		b.logger.Errorf("error generating card_rulings_list table: %s", err.Error())
+		return err
+	}
+
+	err = b.cardService.ClearChangedRulings(ctx)
+	if err != nil {
+		b.logger.Errorf("error clearing changed rulings: %s", err.Error())
 		return err
 	}
 
-	dec := json.NewDecoder(file)
-	// dec.DisallowUnknownFields()
+	return nil
+}
 
-	// read opening bracket
-	_, err = dec.Token()
+// generateOracleCardData calculates the JSON of the oracle cards in the database. Unless full is set, only the
+// changed oracle cards are recalculated.
+func (b *batchRunner) generateOracleCardData(ctx context.Context, full bool) error {
+	b.logger.Println("Calculating oracle_cards.card_json column values...")
+	err := b.cardService.GenerateOracleCardsJSON(ctx, full)
 	if err != nil {
-		b.logger.Errorf("error parsing ruling data: %s", err.Error())
+		b.logger.Errorf("error generating oracle_cards.card_json values: %s", err.Error())
 		return err
 	}
 
-	rulings := []models.ScryfallRuling{}
-	for dec.More() {
-		var ruling models.ScryfallRuling
-		err = dec.Decode(&ruling)
-		if err != nil {
-			b.logger.Errorf("error decoding ruling: %s", err.Error())
+	err = b.cardService.ClearChangedOracleCards(ctx)
+	if err != nil {
+		b.logger.Errorf("error clearing changed oracle cards: %s", err.Error())
+		return err
+	}
 
-			if err.Error() == "not at beginning of value" {
-				b.logger.Fatalf("bulk data file contents in unexpected format - is the scryfall bulk data api broken?")
-			}
-		}
+	return nil
+}
 
-		rulings = append(rulings, ruling)
+// isUnchanged returns whether the provided bulk data file was already processed by a previous run and can be skipped.
+func (b *batchRunner) isUnchanged(ctx context.Context, data models.ScryfallBulkData) (bool, error) {
+	if b.config.Force {
+		return false, nil
+	}
 
-		if len(rulings) == 100 {
-			err = b.cardService.InsertRulings(rulings)
-			if err != nil {
-				b.logger.Errorf("error inserting rulings: %s", err.Error())
-			}
+	processed, err := b.batchService.IsBulkDataProcessed(ctx, data)
+	if err != nil {
+		b.logger.Errorf("error fetching %s bulk data state: %s", data.Type, err.Error())
+		return false, err
+	}
 
-			rulings = []models.ScryfallRuling{}
-		}
+	if processed {
+		b.logger.Printf("Skipping %s bulk data file %s - unchanged since %s.", data.Type, data.ID, data.UpdatedAt)
+	}
+
+	return processed, nil
+}
+
+// prepareBulkDataFile returns the checkpoint to process the provided bulk data file from. If a previous
+// run was interrupted while processing the same file, its checkpoint is returned and its downloaded file
+// is reused, otherwise the file is downloaded from scratch.
+func (b *batchRunner) prepareBulkDataFile(ctx context.Context, data models.ScryfallBulkData, prefix string) (models.BulkDataCheckpoint, error) {
+	checkpoint, err := b.batchService.GetCheckpoint(ctx, data)
+	if err != nil {
+		return models.BulkDataCheckpoint{}, err
 	}
 
-	if len(rulings) > 0 {
-		err = b.cardService.InsertRulings(rulings)
+	if checkpoint.FilePath == "" {
+		checkpoint = models.BulkDataCheckpoint{
+			BulkType:  data.Type,
+			BulkID:    data.ID,
+			UpdatedAt: data.UpdatedAt,
+			FilePath:  filepath.Join(b.config.DataDir, fmt.Sprintf("%s-%v.json", prefix, int32(time.Now().Unix()))),
+		}
+
+		// Save the checkpoint before downloading so that an interrupted download is resumed by the next run
+		err = b.batchService.SaveCheckpoint(ctx, checkpoint)
 		if err != nil {
-			b.logger.Errorf("error inserting rulings: %s", err.Error())
-			return err
+			return models.BulkDataCheckpoint{}, err
 		}
+	} else if _, err = os.Stat(checkpoint.FilePath); err == nil {
+		b.logger.Printf("Resuming %s bulk data file %s from item %d.", data.Type, checkpoint.FilePath, checkpoint.ItemIndex)
+		return checkpoint, nil
 	}
 
-	// read closing bracket
-	_, err = dec.Token()
+	err = b.cardService.DownloadBulkData(ctx, data, checkpoint.FilePath)
 	if err != nil {
-		b.logger.Errorf("error parsing ruling data: %s", err.Error())
+		return models.BulkDataCheckpoint{}, err
+	}
+
+	return checkpoint, nil
+}
+
+func (b *batchRunner) saveCheckpoint(ctx context.Context, checkpoint *models.BulkDataCheckpoint, itemIndex int) error {
+	checkpoint.ItemIndex = itemIndex
+
+	err := b.batchService.SaveCheckpoint(ctx, *checkpoint)
+	if err != nil {
+		b.logger.Errorf("error saving %s checkpoint: %s", checkpoint.BulkType, err.Error())
 		return err
 	}
 
-	b.logger.Println("Calculating card_rulings_list table...")
-	err = b.cardService.GenerateRulingsJSON()
+	return nil
+}
+
+// markProcessed records the provided bulk data file as successfully processed and cleans up its checkpoint and downloaded file.
+func (b *batchRunner) markProcessed(ctx context.Context, data models.ScryfallBulkData, checkpoint models.BulkDataCheckpoint) error {
+	err := b.batchService.MarkBulkDataProcessed(ctx, checkpoint)
 	if err != nil {
-		b.logger.Errorf("error generating card_rulings_list table: %s", err.Error())
+		b.logger.Errorf("error saving %s bulk data state: %s", data.Type, err.Error())
+		return err
+	}
+
+	err = b.batchService.ClearCheckpoint(ctx, data)
+	if err != nil {
+		b.logger.Errorf("error clearing %s checkpoint: %s", data.Type, err.Error())
 		return err
 	}
 
+	err = os.Remove(checkpoint.FilePath)
+	if err != nil {
+		b.logger.Warnf("error removing %s bulk data file: %s", data.Type, err.Error())
+	}
+
 	return nil
 }
+
+func newBatchFailure(bulkType, itemID, stage string, raw []byte, err error) models.BatchFailure {
+	return models.BatchFailure{
+		BulkType: bulkType,
+		ItemID:   itemID,
+		Stage:    stage,
+		Error:    err.Error(),
+		RawJSON:  string(raw),
+	}
+}
+
+func (b *batchRunner) recordFailures(ctx context.Context, failures ...models.BatchFailure) error {
+	err := b.batchService.RecordFailures(ctx, failures)
+	if err != nil {
+		b.logger.Errorf("error recording %d failed item(s): %s", len(failures), err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// openBulkDataFile opens a downloaded bulk data file, transparently decompressing it if it was stored gzipped.
+func openBulkDataFile(filepath string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(file)
+	magic, err := reader.Peek(2)
+	if err != nil && err != io.EOF {
+		file.Close()
+		return nil, err
+	}
+
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return &bulkDataFile{reader, file}, nil
+	}
+
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &bulkDataFile{gz, file}, nil
+}
+
+type bulkDataFile struct {
+	io.Reader
+	file *os.File
+}
+
+func (f *bulkDataFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidArgument is returned when a command is given an invalid argument, which fails however often it is retried
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// StageError is returned by BatchRunner.Run when a stage of processing a bulk data file fails
+type StageError struct {
+	BulkType string
+	Stage    string
+	// Retryable is whether the stage might succeed if the batch is run again without any changes, e.g. after a
+	// network or database error, as opposed to a bulk data file that failed its sanity checks
+	Retryable bool
+	// Cancelled is whether the stage was interrupted by the run being cancelled, e.g. on shutdown
+	Cancelled bool
+	Err       error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("%s %s stage failed: %s", e.BulkType, e.Stage, e.Err.Error())
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports a cancelled stage as context.Canceled, as the error it failed with depends on where it was interrupted
+func (e *StageError) Is(target error) bool {
+	return e.Cancelled && target == context.Canceled
+}
+
+// IsRetryable returns whether the run or command that returned the provided error might succeed if it is retried.
+// Errors not raised by a stage, such as failing to record the run, are assumed to be transient unless they are
+// caused by an invalid argument.
+func IsRetryable(err error) bool {
+	if errors.Is(err, ErrInvalidArgument) {
+		return false
+	}
+
+	var stageErr *StageError
+	if errors.As(err, &stageErr) {
+		return stageErr.Retryable
+	}
+
+	return true
+}
+
+// cancelledError marks the provided error from a run that was cancelled as a cancellation, as the stage that was
+// interrupted may have failed with any error, and a cancelled run is always worth retrying.
+func cancelledError(ctx context.Context, err error) error {
+	if ctx.Err() == nil {
+		return err
+	}
+
+	var stageErr *StageError
+	if errors.As(err, &stageErr) {
+		stageErr.Cancelled = true
+		stageErr.Retryable = true
+		return stageErr
+	}
+
+	return ctx.Err()
+}
+
+// isRetryableSanityError returns whether a failed sanity check is worth retrying. A bulk data file that failed
+// its sanity checks stays broken until Scryfall publishes a new one, except for an unreadable file, which is
+// downloaded again by the next run.
+func isRetryableSanityError(err error) bool {
+	return !errors.Is(err, errSanityCheck) || errors.Is(err, errUnreadableBulkData)
+}
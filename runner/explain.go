@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+)
+
+// Explain logs which inclusion rule included or excluded each card with the provided scryfall ID or name in the
+// last run of each bulk data type
+func (b *batchRunner) Explain(ctx context.Context, query string) error {
+	if query == "" {
+		return fmt.Errorf("%w: explain requires a scryfall ID or card name", ErrInvalidArgument)
+	}
+
+	decisions, err := b.batchService.GetRuleDecisions(ctx, query)
+	if err != nil {
+		b.logger.Errorf("error fetching inclusion decisions for %s: %s", query, err.Error())
+		return err
+	}
+
+	if len(decisions) == 0 {
+		b.logger.Printf("No card with the scryfall ID or name %q was seen in the last run of any bulk data type.", query)
+		return nil
+	}
+
+	for _, decision := range decisions {
+		action := "excluded"
+		if decision.Included {
+			action = "included"
+		}
+
+		b.logger.Printf("%s (%s) was %s from %s by rule %q at %s.", decision.Name, decision.ScryfallID, action, decision.BulkType, decision.Rule, decision.DecidedAt)
+	}
+
+	return nil
+}
+
+// reportRuleDecisions records and logs a summary of the inclusion decisions made while processing a bulk data file.
+func (b *batchRunner) reportRuleDecisions(ctx context.Context, data models.ScryfallBulkData) error {
+	report, err := b.batchService.GenerateRuleReport(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	b.logger.Printf("Included %d and excluded %d %s card(s).", report.Included, report.Excluded, data.Type)
+	for _, count := range report.Rules {
+		if !count.Included {
+			b.logger.Printf("Rule %q excluded %d card(s).", count.Rule, count.Count)
+		}
+	}
+
+	return nil
+}
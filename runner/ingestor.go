@@ -0,0 +1,624 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+	"github.com/BrandonWade/blackblade-batch/repositories"
+	"github.com/BrandonWade/blackblade-batch/rules"
+)
+
+// bulkDataIngestor is the item type independent view of a BulkIngestor
+type bulkDataIngestor interface {
+	BulkType() string
+	Ingest(ctx context.Context) error
+	Replay(ctx context.Context, failure models.BatchFailure) (bool, error)
+	Regenerate(ctx context.Context) error
+	Stats() models.BatchRunBulkData
+}
+
+// BulkIngestor processes a Scryfall bulk data file containing a JSON array of T. It looks up the bulk data
+// file, downloads it, decodes and filters each item, writes the items in batches and finally calculates any
+// derived data. Each bulk data type only needs to configure how its items are filtered and written.
+type BulkIngestor[T any] struct {
+	// Type is the bulk data type as reported by ScryfallBulkData.Type, e.g. default_cards
+	Type string
+
+	// FilePrefix is the prefix of the file the bulk data is downloaded to
+	FilePrefix string
+
+	// ItemID returns the ID recorded for an item that fails to be written
+	ItemID func(item T) string
+
+	// Include decides whether an item should be written. Every item is written if Include is nil, otherwise
+	// each decision is recorded so that it can be explained later.
+	Include func(item T) rules.Decision
+
+	// ItemName returns the name recorded alongside the inclusion decision for an item
+	ItemName func(item T) string
+
+	// Validate, if set, returns the names of any required fields that are empty on an item
+	Validate func(item T) []string
+
+	// ItemKey, if set, identifies each item. The keys of the items seen in a run are recorded so that Sweep can
+	// mark the items that are no longer in the file, or are excluded by the inclusion rules, as removed.
+	ItemKey func(item T) string
+
+	// Sweep, if set, marks the items that were excluded by the inclusion rules as removed, along with the items that
+	// were not seen in the run unless more than the provided percentage of them would be, and returns the number of
+	// items removed
+	Sweep func(ctx context.Context, bulkType string, maxPercent int) (int64, error)
+
+	// Restore, if set, restores the removed items with the provided keys. It is called for items written by Replay,
+	// which may have been removed by a Sweep while they were quarantined.
+	Restore func(ctx context.Context, keys []string) error
+
+	// Write writes a batch of items directly to the database
+	Write func(ctx context.Context, items []T) error
+
+	// WriteCounts, if set, returns the number of items inserted, updated and left unchanged by the writes so far
+	WriteCounts func() (inserted, updated, unchanged int64)
+
+	// Reset, if set, is called before the first batch is written when the file is processed from the start, i.e.
+	// not when resuming from a checkpoint
+	Reset func(ctx context.Context) error
+
+	// Stage, if set, is used instead of Write to stage each batch of items. ResetStaged is called before the
+	// first batch is staged, and MergeStaged once every batch has been staged.
+	Stage       func(ctx context.Context, items []T) error
+	ResetStaged func(ctx context.Context) error
+	MergeStaged func(ctx context.Context) error
+
+	// Generate, if set, calculates any data derived from the written items
+	Generate func(ctx context.Context) error
+
+	// Finish, if set, is called with the bulk data file once every item has been written and Generate has run
+	Finish func(ctx context.Context, data models.ScryfallBulkData) error
+
+	runner *batchRunner
+	stats  *ingestStats
+}
+
+// itemBatch is a batch of items to be written to the database together
+type itemBatch[T any] struct {
+	seq   int
+	items []T
+	raws  []json.RawMessage
+	// keys are the keys of the included items, recorded as seen once the batch is written
+	keys []string
+	// excludedKeys are the keys of the items excluded by the inclusion rules, which are removed by Sweep
+	excludedKeys []string
+	// decisions are the inclusion decisions made for every item covered by the batch, including those filtered out
+	decisions []models.RuleDecision
+	// endIndex is the item index that can be checkpointed once this batch and all batches before it are written
+	endIndex int
+}
+
+// maxBatchDecisions is the number of inclusion decisions after which a batch is sent even if it is not full, so
+// that long runs of filtered out items are still recorded in bounded batches
+const maxBatchDecisions = 5000
+
+// BulkType returns the bulk data type processed by the ingestor
+func (i *BulkIngestor[T]) BulkType() string {
+	return i.Type
+}
+
+// Ingest downloads and processes the bulk data file. Any error is returned as a *StageError.
+func (i *BulkIngestor[T]) Ingest(ctx context.Context) error {
+	b := i.runner
+	apiType := strings.ReplaceAll(i.Type, "_", "-")
+	i.stats = newIngestStats(i.Type)
+
+	b.logger.Printf("Downloading %s bulk data file...", apiType)
+	data, err := b.cardService.GetBulkData(ctx, apiType)
+	if err != nil {
+		b.logger.Errorf("error fetching %s bulk data from api: %s", apiType, err.Error())
+		return i.stageError(stageDownload, true, err)
+	}
+
+	if (data == models.ScryfallBulkData{}) {
+		err = fmt.Errorf("%s bulk data not found", apiType)
+		b.logger.Errorf(err.Error())
+		return i.stageError(stageDownload, false, err)
+	}
+
+	i.stats.update(func(stats *models.BatchRunBulkData) {
+		stats.BulkID = data.ID
+		stats.UpdatedAt = data.UpdatedAt
+	})
+
+	skip, err := b.isUnchanged(ctx, data)
+	if err != nil {
+		return i.stageError(stageDownload, true, err)
+	} else if skip {
+		i.stats.update(func(stats *models.BatchRunBulkData) {
+			stats.Status = models.RunStatusSkipped
+		})
+		return nil
+	}
+
+	err = b.checkUpdatedAt(ctx, data)
+	if err != nil {
+		b.logger.Errorf(err.Error())
+		return i.stageError(stageSanity, isRetryableSanityError(err), err)
+	}
+
+	checkpoint, err := b.prepareBulkDataFile(ctx, data, i.FilePrefix)
+	if err != nil {
+		b.logger.Errorf("error downloading %s data from api: %s", apiType, err.Error())
+		return i.stageError(stageDownload, true, err)
+	}
+	i.stats.endStage(stageDownload)
+
+	// Check the whole file before anything is written, unless a previous run already started writing it
+	if checkpoint.ItemIndex == 0 {
+		err = i.checkSanity(ctx, data, &checkpoint)
+		if err != nil {
+			b.logger.Errorf(err.Error())
+			return i.stageError(stageSanity, isRetryableSanityError(err), err)
+		}
+		i.stats.endStage(stageSanity)
+	}
+
+	b.logger.Printf("Processing %s bulk data file...", apiType)
+
+	file, err := openBulkDataFile(checkpoint.FilePath)
+	if err != nil {
+		b.logger.Errorf("error opening %s data file: %s", apiType, err.Error())
+		return i.stageError(stageIngest, true, err)
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(file)
+	// dec.DisallowUnknownFields()
+
+	// read opening bracket
+	_, err = dec.Token()
+	if err != nil {
+		b.logger.Errorf("error parsing %s data: %s", apiType, err.Error())
+		return i.stageError(stageIngest, false, err)
+	}
+
+	if i.Include != nil && checkpoint.ItemIndex == 0 {
+		err = b.batchService.ResetRuleDecisions(ctx, data)
+		if err != nil {
+			b.logger.Errorf("error resetting %s inclusion decisions: %s", apiType, err.Error())
+			return i.stageError(stageIngest, true, err)
+		}
+	}
+
+	if i.ItemKey != nil && checkpoint.ItemIndex == 0 {
+		err = b.batchService.ResetSeenItems(ctx, data)
+		if err != nil {
+			b.logger.Errorf("error resetting %s seen items: %s", apiType, err.Error())
+			return i.stageError(stageIngest, true, err)
+		}
+	}
+
+	if i.Reset != nil && checkpoint.ItemIndex == 0 {
+		err = i.Reset(ctx)
+		if err != nil {
+			b.logger.Errorf("error resetting %s: %s", apiType, err.Error())
+			return i.stageError(stageIngest, true, err)
+		}
+	}
+
+	if i.Stage != nil && checkpoint.ItemIndex == 0 {
+		err = i.ResetStaged(ctx)
+		if err != nil {
+			b.logger.Errorf("error resetting %s staging tables: %s", apiType, err.Error())
+			return i.stageError(stageIngest, true, err)
+		}
+	}
+
+	err = i.ingest(ctx, data, dec, &checkpoint)
+	if err != nil {
+		b.logger.Errorf("error processing %s: %s", apiType, err.Error())
+		return i.stageError(stageIngest, true, err)
+	}
+	i.stats.endStage(stageIngest)
+
+	if i.Stage != nil {
+		b.logger.Printf("Merging %s staging tables...", apiType)
+		err = i.MergeStaged(ctx)
+		if err != nil {
+			b.logger.Errorf("error merging %s staging tables: %s", apiType, err.Error())
+			return i.stageError(stageMerge, true, err)
+		}
+		i.stats.endStage(stageMerge)
+	}
+
+	if i.Sweep != nil {
+		err = i.sweep(ctx)
+		if err != nil {
+			b.logger.Errorf("error removing unseen %s items: %s", apiType, err.Error())
+			return i.stageError(stageSweep, true, err)
+		}
+		i.stats.endStage(stageSweep)
+	}
+
+	err = i.Regenerate(ctx)
+	if err != nil {
+		return i.stageError(stageGenerate, true, err)
+	}
+	i.stats.endStage(stageGenerate)
+
+	if i.Finish != nil {
+		err = i.Finish(ctx, data)
+		if err != nil {
+			b.logger.Errorf("error finishing %s: %s", apiType, err.Error())
+			return i.stageError(stageFinish, true, err)
+		}
+	}
+
+	if i.Include != nil {
+		err = b.reportRuleDecisions(ctx, data)
+		if err != nil {
+			b.logger.Errorf("error generating %s inclusion report: %s", apiType, err.Error())
+			return i.stageError(stageFinish, true, err)
+		}
+	}
+
+	err = b.markProcessed(ctx, data, checkpoint)
+	if err != nil {
+		return i.stageError(stageFinish, true, err)
+	}
+
+	i.stats.endStage(stageFinish)
+	i.stats.update(func(stats *models.BatchRunBulkData) {
+		stats.Status = models.RunStatusSucceeded
+	})
+
+	return nil
+}
+
+// stageError wraps an error raised by the provided stage of processing the bulk data file.
+func (i *BulkIngestor[T]) stageError(stage string, retryable bool, err error) error {
+	return &StageError{
+		BulkType:  i.Type,
+		Stage:     stage,
+		Retryable: retryable,
+		Err:       err,
+	}
+}
+
+// Stats returns the statistics of the last call to Ingest
+func (i *BulkIngestor[T]) Stats() models.BatchRunBulkData {
+	if i.stats == nil {
+		return models.BatchRunBulkData{BulkType: i.Type}
+	}
+
+	stats := i.stats.snapshot()
+	if i.WriteCounts != nil {
+		stats.Inserted, stats.Updated, stats.Unchanged = i.WriteCounts()
+	}
+
+	return stats
+}
+
+// Replay re-attempts writing a previously failed item directly to the database. It returns whether the item was
+// written, as items that are now filtered out are resolved without being written.
+func (i *BulkIngestor[T]) Replay(ctx context.Context, failure models.BatchFailure) (bool, error) {
+	var item T
+	err := json.Unmarshal([]byte(failure.RawJSON), &item)
+	if err != nil {
+		return false, err
+	}
+
+	if i.Include != nil {
+		decision := i.Include(item)
+		err = i.runner.batchService.RecordRuleDecisions(ctx, []models.RuleDecision{i.newRuleDecision(item, decision)})
+		if err != nil {
+			return false, err
+		}
+
+		if !decision.Included {
+			return false, nil
+		}
+	}
+
+	err = i.Write(ctx, []T{item})
+	if err != nil {
+		return false, err
+	}
+
+	if i.Restore != nil && i.ItemKey != nil {
+		err = i.Restore(ctx, []string{i.ItemKey(item)})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// Regenerate calculates any data derived from the written items
+func (i *BulkIngestor[T]) Regenerate(ctx context.Context) error {
+	if i.Generate == nil {
+		return nil
+	}
+
+	return i.Generate(ctx)
+}
+
+// ingest runs the ingestion pipeline: one goroutine reads items from the file, one decodes, filters and
+// batches them, and the configured number of workers write the batches to the database. The channels
+// between each stage are bounded so that memory use stays constant regardless of the file size.
+func (i *BulkIngestor[T]) ingest(ctx context.Context, data models.ScryfallBulkData, dec *json.Decoder, checkpoint *models.BulkDataCheckpoint) error {
+	b := i.runner
+	p := newPipeline(ctx)
+	items := make(chan bulkItem, b.config.BatchSize)
+	batches := make(chan itemBatch[T], b.config.Parallelism)
+	tracker := newCheckpointTracker(checkpoint)
+
+	p.Go(func(ctx context.Context) error {
+		defer close(items)
+		return b.decodeBulkItems(ctx, dec, *checkpoint, items)
+	})
+
+	p.Go(func(ctx context.Context) error {
+		defer close(batches)
+		return i.batchItems(ctx, items, batches)
+	})
+
+	for w := 0; w < b.config.Parallelism; w++ {
+		p.Go(func(ctx context.Context) error {
+			return i.writeBatches(ctx, batches, tracker)
+		})
+	}
+
+	return p.Wait()
+}
+
+// batchItems decodes and filters each item and groups them into batches of the configured size.
+func (i *BulkIngestor[T]) batchItems(ctx context.Context, items <-chan bulkItem, batches chan<- itemBatch[T]) error {
+	b := i.runner
+	var decoded, filtered, failed int64
+	defer func() {
+		i.stats.update(func(stats *models.BatchRunBulkData) {
+			stats.Decoded += decoded
+			stats.Filtered += filtered
+			stats.Failed += failed
+		})
+	}()
+
+	batch := itemBatch[T]{}
+	send := func() error {
+		select {
+		case batches <- batch:
+			batch = itemBatch[T]{seq: batch.seq + 1}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for {
+		var raw bulkItem
+		var ok bool
+		select {
+		case raw, ok = <-items:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if !ok {
+			break
+		}
+
+		batch.endIndex = raw.index
+
+		var item T
+		err := json.Unmarshal(raw.raw, &item)
+		if err != nil {
+			item = decodeFailedItem[T](raw.raw)
+			itemID := i.ItemID(item)
+			b.logger.Errorf("error decoding %s item %s: %s", i.Type, itemID, err.Error())
+			failed++
+
+			// Like items that fail to be written, items that fail to decode are seen so that they are not removed
+			if i.ItemKey != nil && itemID != "" {
+				batch.keys = append(batch.keys, i.ItemKey(item))
+			}
+
+			err = b.recordFailures(ctx, newBatchFailure(i.Type, itemID, models.FailureStageDecode, raw.raw, err))
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+		decoded++
+
+		included := true
+		if i.Include != nil {
+			decision := i.Include(item)
+			batch.decisions = append(batch.decisions, i.newRuleDecision(item, decision))
+			included = decision.Included
+		}
+
+		if !included {
+			filtered++
+
+			if i.ItemKey != nil {
+				batch.excludedKeys = append(batch.excludedKeys, i.ItemKey(item))
+			}
+		} else {
+			batch.items = append(batch.items, item)
+			batch.raws = append(batch.raws, raw.raw)
+
+			if i.ItemKey != nil {
+				batch.keys = append(batch.keys, i.ItemKey(item))
+			}
+		}
+
+		if len(batch.items) == b.config.BatchSize || len(batch.decisions) == maxBatchDecisions {
+			err = send()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Always send the final batch, even if every item in it was filtered out, so that the checkpoint reaches the end of the file
+	if batch.endIndex > 0 {
+		return send()
+	}
+
+	return nil
+}
+
+// writeBatches writes each batch of items to the database and advances the checkpoint.
+func (i *BulkIngestor[T]) writeBatches(ctx context.Context, batches <-chan itemBatch[T], tracker *checkpointTracker) error {
+	for {
+		var batch itemBatch[T]
+		var ok bool
+		select {
+		case batch, ok = <-batches:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if !ok {
+			return nil
+		}
+
+		if len(batch.items) > 0 {
+			err := i.writeBatch(ctx, batch.items, batch.raws)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Items are seen even if they fail to be written, so that they are not removed
+		if len(batch.keys) > 0 {
+			err := i.runner.batchService.RecordSeenItems(ctx, i.Type, batch.keys, false)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(batch.excludedKeys) > 0 {
+			err := i.runner.batchService.RecordSeenItems(ctx, i.Type, batch.excludedKeys, true)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(batch.decisions) > 0 {
+			err := i.runner.batchService.RecordRuleDecisions(ctx, batch.decisions)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := i.runner.completeBatch(ctx, tracker, batch.seq, batch.endIndex)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// decodeFailedItem decodes each field of an item that failed to decode on its own, skipping the fields that fail, so
+// that the item can still be identified by the fields that are valid.
+func decodeFailedItem[T any](raw json.RawMessage) T {
+	var item T
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(raw, &fields)
+	if err != nil {
+		return item
+	}
+
+	for name, value := range fields {
+		field, err := json.Marshal(map[string]json.RawMessage{name: value})
+		if err != nil {
+			continue
+		}
+
+		// A field that fails to decode is left empty
+		json.Unmarshal(field, &item)
+	}
+
+	return item
+}
+
+// writeBatch writes the provided items. If the write fails, the items are split in half and retried
+// recursively until each failing item is isolated and quarantined, so that every other item is committed.
+func (i *BulkIngestor[T]) writeBatch(ctx context.Context, items []T, raws []json.RawMessage) error {
+	b := i.runner
+	write := i.Write
+	if i.Stage != nil {
+		write = i.Stage
+	}
+
+	err := write(ctx, items)
+	if err == nil {
+		i.stats.update(func(stats *models.BatchRunBulkData) {
+			stats.Written += int64(len(items))
+		})
+		return nil
+	}
+
+	// A write interrupted by a shutdown was rolled back and is retried by the next run, so it isn't a failure
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if len(items) == 1 {
+		itemID := i.ItemID(items[0])
+		b.logger.Errorf("error writing %s item %s: %s", i.Type, itemID, err.Error())
+		i.stats.update(func(stats *models.BatchRunBulkData) {
+			stats.Failed++
+		})
+		return b.recordFailures(ctx, newBatchFailure(i.Type, itemID, models.FailureStageUpsert, raws[0], err))
+	}
+
+	b.logger.Warnf("error writing %d %s items, retrying in smaller batches: %s", len(items), i.Type, err.Error())
+
+	mid := len(items) / 2
+	err = i.writeBatch(ctx, items[:mid], raws[:mid])
+	if err != nil {
+		return err
+	}
+
+	return i.writeBatch(ctx, items[mid:], raws[mid:])
+}
+
+// sweep marks the items that were not seen in the run, or were excluded by the inclusion rules, as removed.
+// Exceeding the removal threshold is not an error, as the unseen items are left in place to be removed by a later
+// run once the cause has been checked.
+func (i *BulkIngestor[T]) sweep(ctx context.Context) error {
+	b := i.runner
+	removed, err := i.Sweep(ctx, i.Type, b.config.TombstoneMaxPercent)
+
+	// Items excluded by the inclusion rules are still removed when the threshold is exceeded
+	var thresholdErr *repositories.TombstoneThresholdError
+	if errors.As(err, &thresholdErr) {
+		b.logger.Warnf("skipping removal of unseen %s items: %s", i.Type, err.Error())
+	} else if err != nil {
+		return err
+	}
+
+	b.logger.Printf("Marked %d unseen or excluded %s item(s) as removed.", removed, i.Type)
+	return nil
+}
+
+// newRuleDecision builds the record of the inclusion decision made for an item.
+func (i *BulkIngestor[T]) newRuleDecision(item T, decision rules.Decision) models.RuleDecision {
+	ruleDecision := models.RuleDecision{
+		BulkType:   i.Type,
+		ScryfallID: i.ItemID(item),
+		Included:   decision.Included,
+		Rule:       decision.Rule,
+	}
+
+	if i.ItemName != nil {
+		ruleDecision.Name = i.ItemName(item)
+	}
+
+	return ruleDecision
+}
@@ -0,0 +1,205 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+	"github.com/BrandonWade/blackblade-batch/rules"
+	"github.com/BrandonWade/blackblade-batch/services"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeBatchService records the calls made by the runner that the tests check. Calling any other method panics.
+type fakeBatchService struct {
+	services.BatchService
+	failures    []models.BatchFailure
+	checkpoints []int
+}
+
+func (f *fakeBatchService) RecordFailures(ctx context.Context, failures []models.BatchFailure) error {
+	f.failures = append(f.failures, failures...)
+	return nil
+}
+
+func (f *fakeBatchService) SaveCheckpoint(ctx context.Context, checkpoint models.BulkDataCheckpoint) error {
+	f.checkpoints = append(f.checkpoints, checkpoint.ItemIndex)
+	return nil
+}
+
+// newTestRunner returns a batchRunner backed by the provided batch service that discards its logs.
+func newTestRunner(batchService services.BatchService) *batchRunner {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return &batchRunner{
+		logger:       logger,
+		batchService: batchService,
+	}
+}
+
+func TestWriteBatchIsolatesFailingItems(t *testing.T) {
+	tests := []struct {
+		name        string
+		items       []string
+		bad         []string
+		wantWritten []string
+		wantFailed  []string
+	}{
+		{"no failures", []string{"a", "b", "c"}, nil, []string{"a", "b", "c"}, nil},
+		{"single item", []string{"a"}, []string{"a"}, nil, []string{"a"}},
+		{"first item", []string{"a", "b", "c", "d"}, []string{"a"}, []string{"b", "c", "d"}, []string{"a"}},
+		{"last item", []string{"a", "b", "c", "d", "e"}, []string{"e"}, []string{"a", "b", "c", "d"}, []string{"e"}},
+		{"middle item", []string{"a", "b", "c", "d", "e", "f", "g"}, []string{"d"}, []string{"a", "b", "c", "e", "f", "g"}, []string{"d"}},
+		{"several items", []string{"a", "b", "c", "d", "e", "f"}, []string{"b", "f"}, []string{"a", "c", "d", "e"}, []string{"b", "f"}},
+		{"every item", []string{"a", "b"}, []string{"a", "b"}, nil, []string{"a", "b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			batchService := &fakeBatchService{}
+			bad := map[string]bool{}
+			for _, item := range test.bad {
+				bad[item] = true
+			}
+
+			var written []string
+			ingestor := &BulkIngestor[string]{
+				Type:   "test",
+				ItemID: func(item string) string { return item },
+				Write: func(ctx context.Context, items []string) error {
+					for _, item := range items {
+						if bad[item] {
+							return errors.New("bad item")
+						}
+					}
+
+					written = append(written, items...)
+					return nil
+				},
+				runner: newTestRunner(batchService),
+				stats:  newIngestStats("test"),
+			}
+
+			raws := make([]json.RawMessage, 0, len(test.items))
+			for _, item := range test.items {
+				raws = append(raws, json.RawMessage(`"`+item+`"`))
+			}
+
+			err := ingestor.writeBatch(context.Background(), test.items, raws)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sort.Strings(written)
+			if !reflect.DeepEqual(written, test.wantWritten) {
+				t.Errorf("got written %v, want %v", written, test.wantWritten)
+			}
+
+			var failed []string
+			for _, failure := range batchService.failures {
+				failed = append(failed, failure.ItemID)
+				if failure.RawJSON != `"`+failure.ItemID+`"` {
+					t.Errorf("got raw json %s for item %s", failure.RawJSON, failure.ItemID)
+				}
+				if failure.Stage != models.FailureStageUpsert {
+					t.Errorf("got stage %s for item %s, want %s", failure.Stage, failure.ItemID, models.FailureStageUpsert)
+				}
+			}
+			if !reflect.DeepEqual(failed, test.wantFailed) {
+				t.Errorf("got failed %v, want %v", failed, test.wantFailed)
+			}
+
+			stats := ingestor.Stats()
+			if stats.Written != int64(len(test.wantWritten)) || stats.Failed != int64(len(test.wantFailed)) {
+				t.Errorf("got %d written and %d failed, want %d and %d", stats.Written, stats.Failed, len(test.wantWritten), len(test.wantFailed))
+			}
+		})
+	}
+}
+
+func TestWriteBatchCancelled(t *testing.T) {
+	batchService := &fakeBatchService{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ingestor := &BulkIngestor[string]{
+		Type:   "test",
+		ItemID: func(item string) string { return item },
+		Write: func(ctx context.Context, items []string) error {
+			cancel()
+			return ctx.Err()
+		},
+		runner: newTestRunner(batchService),
+		stats:  newIngestStats("test"),
+	}
+
+	err := ingestor.writeBatch(ctx, []string{"a", "b"}, []json.RawMessage{[]byte(`"a"`), []byte(`"b"`)})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+
+	if len(batchService.failures) > 0 {
+		t.Errorf("got %d failures recorded for a cancelled write, want none", len(batchService.failures))
+	}
+}
+
+func TestBatchItemsRecordsExcludedKeys(t *testing.T) {
+	tests := []struct {
+		name             string
+		items            []string
+		excluded         []string
+		wantKeys         []string
+		wantExcludedKeys []string
+	}{
+		{"every item included", []string{"a", "b"}, nil, []string{"a", "b"}, nil},
+		{"some items excluded", []string{"a", "b", "c"}, []string{"b"}, []string{"a", "c"}, []string{"b"}},
+		{"every item excluded", []string{"a", "b"}, []string{"a", "b"}, nil, []string{"a", "b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			excluded := map[string]bool{}
+			for _, item := range test.excluded {
+				excluded[item] = true
+			}
+
+			b := newTestRunner(&fakeBatchService{})
+			b.config.BatchSize = len(test.items) + 1
+			ingestor := &BulkIngestor[string]{
+				Type:   "test",
+				ItemID: func(item string) string { return item },
+				Include: func(item string) rules.Decision {
+					return rules.Decision{Included: !excluded[item], Rule: "test"}
+				},
+				ItemKey: func(item string) string { return item },
+				runner:  b,
+				stats:   newIngestStats("test"),
+			}
+
+			items := make(chan bulkItem, len(test.items))
+			for index, item := range test.items {
+				items <- bulkItem{json.RawMessage(`"` + item + `"`), index + 1}
+			}
+			close(items)
+
+			batches := make(chan itemBatch[string], 1)
+			err := ingestor.batchItems(context.Background(), items, batches)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			batch := <-batches
+			if !reflect.DeepEqual(batch.keys, test.wantKeys) {
+				t.Errorf("got keys %v, want %v", batch.keys, test.wantKeys)
+			}
+			if !reflect.DeepEqual(batch.excludedKeys, test.wantExcludedKeys) {
+				t.Errorf("got excluded keys %v, want %v", batch.excludedKeys, test.wantExcludedKeys)
+			}
+		})
+	}
+}
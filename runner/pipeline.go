@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+)
+
+// pipeline runs a set of stages concurrently and cancels all of them as soon as any stage fails
+type pipeline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+}
+
+func newPipeline(parent context.Context) *pipeline {
+	ctx, cancel := context.WithCancel(parent)
+
+	return &pipeline{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Go runs the provided stage in a new goroutine
+func (p *pipeline) Go(stage func(ctx context.Context) error) {
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+
+		err := stage(p.ctx)
+		if err != nil {
+			p.once.Do(func() {
+				p.err = err
+				p.cancel()
+			})
+		}
+	}()
+}
+
+// Wait waits for every stage to finish and returns the first error encountered, if any
+func (p *pipeline) Wait() error {
+	p.wg.Wait()
+	p.cancel()
+
+	return p.err
+}
+
+// bulkItem is a single undecoded item from a bulk data file
+type bulkItem struct {
+	raw json.RawMessage
+	// index is the number of items read from the file up to and including this one
+	index int
+}
+
+// checkpointTracker advances a checkpoint as batches are written, which may happen out of order. The checkpoint
+// only ever moves past a batch once every batch before it has been written too.
+type checkpointTracker struct {
+	mu         sync.Mutex
+	checkpoint *models.BulkDataCheckpoint
+	next       int
+	completed  map[int]int
+}
+
+func newCheckpointTracker(checkpoint *models.BulkDataCheckpoint) *checkpointTracker {
+	return &checkpointTracker{
+		checkpoint: checkpoint,
+		completed:  map[int]int{},
+	}
+}
+
+// decodeBulkItems reads each item from the JSON array in the provided decoder and sends it to items, skipping
+// any items that were already processed according to the checkpoint. The opening bracket must already have been read.
+func (b *batchRunner) decodeBulkItems(ctx context.Context, dec *json.Decoder, checkpoint models.BulkDataCheckpoint, items chan<- bulkItem) error {
+	itemIndex := 0
+	for dec.More() {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		itemIndex++
+		if err != nil {
+			b.logger.Errorf("error decoding %s item %d: %s", checkpoint.BulkType, itemIndex, err.Error())
+			b.logger.Errorf("bulk data file contents in unexpected format - is the scryfall bulk data api broken?")
+			return err
+		}
+
+		// Skip over any items that were already processed by a previous run
+		if itemIndex <= checkpoint.ItemIndex {
+			if itemIndex%cancelCheckInterval == 0 && ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			continue
+		}
+
+		select {
+		case items <- bulkItem{raw, itemIndex}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// read closing bracket
+	_, err := dec.Token()
+	if err != nil {
+		b.logger.Errorf("error parsing %s data: %s", checkpoint.BulkType, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// completeBatch records a batch as written and saves the checkpoint if it can be advanced.
+func (b *batchRunner) completeBatch(ctx context.Context, tracker *checkpointTracker, seq, endIndex int) error {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.completed[seq] = endIndex
+
+	itemIndex := -1
+	for {
+		index, ok := tracker.completed[tracker.next]
+		if !ok {
+			break
+		}
+
+		delete(tracker.completed, tracker.next)
+		tracker.next++
+		itemIndex = index
+	}
+
+	if itemIndex < 0 {
+		return nil
+	}
+
+	return b.saveCheckpoint(ctx, tracker.checkpoint, itemIndex)
+}
@@ -0,0 +1,53 @@
+package runner
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+)
+
+func TestCompleteBatchOutOfOrder(t *testing.T) {
+	// Batch n ends at item index (n+1)*10
+	tests := []struct {
+		name            string
+		order           []int
+		wantCheckpoints []int
+	}{
+		{"in order", []int{0, 1, 2}, []int{10, 20, 30}},
+		{"reversed", []int{2, 1, 0}, []int{30}},
+		{"first batch last", []int{1, 2, 3, 0}, []int{40}},
+		{"gap filled later", []int{0, 2, 3, 1, 4}, []int{10, 40, 50}},
+		{"interleaved", []int{1, 0, 3, 2, 5, 4}, []int{20, 40, 60}},
+		{"first batch never completes", []int{1, 2, 3}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			batchService := &fakeBatchService{}
+			b := newTestRunner(batchService)
+			checkpoint := &models.BulkDataCheckpoint{BulkType: "test"}
+			tracker := newCheckpointTracker(checkpoint)
+
+			for _, seq := range test.order {
+				err := b.completeBatch(context.Background(), tracker, seq, (seq+1)*10)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if !reflect.DeepEqual(batchService.checkpoints, test.wantCheckpoints) {
+				t.Errorf("got checkpoints %v, want %v", batchService.checkpoints, test.wantCheckpoints)
+			}
+
+			wantIndex := 0
+			if n := len(test.wantCheckpoints); n > 0 {
+				wantIndex = test.wantCheckpoints[n-1]
+			}
+			if checkpoint.ItemIndex != wantIndex {
+				t.Errorf("got item index %d, want %d", checkpoint.ItemIndex, wantIndex)
+			}
+		})
+	}
+}
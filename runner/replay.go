@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// ReplayFailures re-attempts every quarantined bulk data item that has not yet been successfully replayed. Items
+// that fail again are recorded and don't fail the replay.
+func (b *batchRunner) ReplayFailures(ctx context.Context) error {
+	b.logger.Println("Replaying failures...")
+	start := time.Now()
+
+	failures, err := b.batchService.GetUnresolvedFailures(ctx)
+	if err != nil {
+		b.logger.Errorf("error fetching failures: %s", err.Error())
+		return err
+	}
+
+	replayed := map[string]bool{}
+	resolved := 0
+	for _, failure := range failures {
+		if ctx.Err() != nil {
+			b.logger.Warnf("stopping replay after %d of %d failure(s): %s", resolved, len(failures), ctx.Err().Error())
+			return ctx.Err()
+		}
+
+		// Failures are replayed regardless of whether their bulk data type is still configured
+		ingestor := b.ingestor(failure.BulkType)
+		if ingestor == nil {
+			b.logger.Warnf("skipping failure %d with unknown bulk data type %s", failure.ID, failure.BulkType)
+			continue
+		}
+
+		written, err := ingestor.Replay(ctx, failure)
+		if err != nil && ctx.Err() != nil {
+			// The failure was interrupted by a shutdown rather than failing again, so it is left as it was
+			b.logger.Warnf("stopping replay after %d of %d failure(s): %s", resolved, len(failures), ctx.Err().Error())
+			return ctx.Err()
+		} else if err != nil {
+			b.logger.Errorf("error replaying failure %d for %s %s: %s", failure.ID, failure.BulkType, failure.ItemID, err.Error())
+
+			err = b.batchService.UpdateFailure(ctx, failure.ID, err)
+			if err != nil {
+				b.logger.Errorf("error updating failure %d: %s", failure.ID, err.Error())
+				return err
+			}
+
+			continue
+		}
+
+		err = b.batchService.ResolveFailure(ctx, failure.ID)
+		if err != nil {
+			b.logger.Errorf("error resolving failure %d: %s", failure.ID, err.Error())
+			return err
+		}
+
+		resolved++
+		if written {
+			replayed[failure.BulkType] = true
+		}
+	}
+
+	for bulkType := range replayed {
+		err = b.ingestor(bulkType).Regenerate(ctx)
+		if err != nil {
+			b.logger.Errorf("error regenerating %s data after replaying failures: %s", bulkType, err.Error())
+			return err
+		}
+	}
+
+	elapsed := time.Since(start)
+	b.logger.Printf("Replayed %d of %d failure(s) in %s.", resolved, len(failures), elapsed)
+
+	return nil
+}
+
+// RestoreDerivedTables rolls every derived table back to the generation before the last one, e.g. after a bad run
+func (b *batchRunner) RestoreDerivedTables(ctx context.Context) error {
+	restored, err := b.cardService.RestoreDerivedTables(ctx)
+	if err != nil {
+		b.logger.Errorf("error restoring derived tables: %s", err.Error())
+		return err
+	}
+
+	if len(restored) == 0 {
+		b.logger.Println("No previous generation of the derived tables to restore.")
+		return nil
+	}
+
+	b.logger.Printf("Restored the previous generation of %s.", strings.Join(restored, ", "))
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+)
+
+// runsListed is the number of most recent runs listed by Runs
+const runsListed = 20
+
+// detachedContext carries the values of its parent context but is never cancelled
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// detached returns a context with the values of the provided context that is not cancelled along with it, for
+// recording the outcome of a run after it was cancelled. The process' shutdown grace period still bounds it.
+func detached(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+// recordRunBulkData records the statistics of the bulk data file processed by the provided ingestor in a run.
+// Failing to record them is logged but doesn't fail the run.
+func (b *batchRunner) recordRunBulkData(ctx context.Context, runID int64, ingestor bulkDataIngestor, ingestErr error) {
+	stats := ingestor.Stats()
+	stats.RunID = runID
+	if errors.Is(ingestErr, context.Canceled) {
+		stats.Status = models.RunStatusCancelled
+		stats.Error = ingestErr.Error()
+	} else if ingestErr != nil {
+		stats.Status = models.RunStatusFailed
+		stats.Error = ingestErr.Error()
+	}
+
+	err := b.batchService.RecordRunBulkData(ctx, stats)
+	if err != nil {
+		b.logger.Errorf("error recording %s statistics for run %d: %s", stats.BulkType, runID, err.Error())
+	}
+}
+
+// Runs logs the most recent runs of the batch, or the statistics of the run with the provided ID
+func (b *batchRunner) Runs(ctx context.Context, id string) error {
+	if id == "" {
+		return b.listRuns(ctx)
+	}
+
+	runID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid run ID %q", ErrInvalidArgument, id)
+	}
+
+	return b.inspectRun(ctx, runID)
+}
+
+// listRuns logs a summary of the most recent runs of the batch.
+func (b *batchRunner) listRuns(ctx context.Context) error {
+	runs, err := b.batchService.GetRuns(ctx, runsListed)
+	if err != nil {
+		b.logger.Errorf("error fetching runs: %s", err.Error())
+		return err
+	}
+
+	if len(runs) == 0 {
+		b.logger.Println("No runs have been recorded.")
+		return nil
+	}
+
+	for _, run := range runs {
+		b.logger.Printf("Run %d started at %s: %s%s.", run.ID, run.StartedAt, run.Status, runSuffix(run))
+	}
+
+	return nil
+}
+
+// inspectRun logs the statistics of the specified run and of each bulk data file it processed.
+func (b *batchRunner) inspectRun(ctx context.Context, id int64) error {
+	run, data, err := b.batchService.GetRun(ctx, id)
+	if err != nil {
+		b.logger.Errorf("error fetching run %d: %s", id, err.Error())
+		return err
+	}
+
+	if run.ID == 0 {
+		return fmt.Errorf("%w: no run with the ID %d has been recorded", ErrInvalidArgument, id)
+	}
+
+	b.logger.Printf("Run %d started at %s: %s%s.", run.ID, run.StartedAt, run.Status, runSuffix(run))
+	for _, d := range data {
+		b.logger.Printf("%s (%s, updated at %s): %s.", d.BulkType, d.BulkID, d.UpdatedAt, d.Status)
+		if d.Error != "" {
+			b.logger.Printf("  Error: %s", d.Error)
+		}
+
+		b.logger.Printf("  Decoded %d, filtered out %d, written %d and failed %d item(s).", d.Decoded, d.Filtered, d.Written, d.Failed)
+		b.logger.Printf("  Inserted %d, updated %d and left %d item(s) unchanged.", d.Inserted, d.Updated, d.Unchanged)
+		for _, stage := range d.Stages {
+			b.logger.Printf("  Stage %s took %s.", stage.Stage, time.Duration(stage.Duration)*time.Millisecond)
+		}
+	}
+
+	return nil
+}
+
+// runSuffix describes when the provided run finished and why it failed, if it did.
+func runSuffix(run models.BatchRun) string {
+	suffix := ""
+	if run.FinishedAt != "" {
+		suffix += ", finished at " + run.FinishedAt
+	}
+
+	if run.Error != "" {
+		suffix += ": " + run.Error
+	}
+
+	return suffix
+}
@@ -0,0 +1,192 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+)
+
+// errSanityCheck is returned when a bulk data file looks broken or truncated and is not processed
+var errSanityCheck = errors.New("sanity check failed")
+
+// errUnreadableBulkData is returned when a bulk data file cannot be read to the end, e.g. after a truncated download
+var errUnreadableBulkData = fmt.Errorf("%w: bulk data file is unreadable", errSanityCheck)
+
+// cancelCheckInterval is the number of items read from a bulk data file between checks for the run being cancelled
+const cancelCheckInterval = 1000
+
+// bulkDataScan is the result of reading a whole bulk data file without writing any of it
+type bulkDataScan struct {
+	items    int
+	invalid  int
+	included int
+	// missing is the number of included items missing each required field
+	missing map[string]int
+}
+
+// checkUpdatedAt fails if the provided bulk data file is older than the last one successfully processed.
+func (b *batchRunner) checkUpdatedAt(ctx context.Context, data models.ScryfallBulkData) error {
+	state, err := b.batchService.GetBulkDataState(ctx, data)
+	if err != nil {
+		b.logger.Errorf("error fetching %s bulk data state: %s", data.Type, err.Error())
+		return err
+	}
+
+	if state.UpdatedAt == "" {
+		return nil
+	}
+
+	updatedAt, err := time.Parse(time.RFC3339, data.UpdatedAt)
+	if err != nil {
+		return b.sanityCheckFailed(data, fmt.Sprintf("invalid updated_at %q", data.UpdatedAt))
+	}
+
+	previousUpdatedAt, err := time.Parse(time.RFC3339, state.UpdatedAt)
+	if err == nil && updatedAt.Before(previousUpdatedAt) {
+		return b.sanityCheckFailed(data, fmt.Sprintf("updated_at %s is before the previous run's %s", data.UpdatedAt, state.UpdatedAt))
+	}
+
+	return nil
+}
+
+// checkSanity reads the whole bulk data file before anything is written, recording the number of items and
+// included items in the checkpoint. It fails if the file cannot be read to the end, if the number of included
+// items dropped too far since the last successful run, or if too many included items are missing a required field.
+func (i *BulkIngestor[T]) checkSanity(ctx context.Context, data models.ScryfallBulkData, checkpoint *models.BulkDataCheckpoint) error {
+	b := i.runner
+	b.logger.Printf("Checking %s bulk data file...", data.Type)
+
+	scan, err := i.scan(ctx, checkpoint.FilePath)
+	if ctx.Err() != nil {
+		// The file is fine, the scan was just interrupted, so it is scanned again by the next run
+		return ctx.Err()
+	} else if err != nil {
+		// The file is downloaded again by the next run, as there is no point resuming from a broken file
+		clearErr := b.batchService.ClearCheckpoint(ctx, data)
+		if clearErr != nil {
+			b.logger.Errorf("error clearing %s checkpoint: %s", data.Type, clearErr.Error())
+		}
+
+		removeErr := os.Remove(checkpoint.FilePath)
+		if removeErr != nil {
+			b.logger.Warnf("error removing %s bulk data file: %s", data.Type, removeErr.Error())
+		}
+
+		return fmt.Errorf("%w: %s bulk data file %s: %s", errUnreadableBulkData, data.Type, checkpoint.FilePath, err.Error())
+	}
+
+	checkpoint.ItemCount = scan.items
+	checkpoint.IncludedCount = scan.included
+	err = b.saveCheckpoint(ctx, checkpoint, checkpoint.ItemIndex)
+	if err != nil {
+		return err
+	}
+
+	b.logger.Printf("Found %d %s item(s), %d included and %d invalid.", scan.items, data.Type, scan.included, scan.invalid)
+
+	state, err := b.batchService.GetBulkDataState(ctx, data)
+	if err != nil {
+		b.logger.Errorf("error fetching %s bulk data state: %s", data.Type, err.Error())
+		return err
+	}
+
+	drop := state.IncludedCount - scan.included
+	if state.IncludedCount > 0 && drop*100 > b.config.MaxCountDropPercent*state.IncludedCount {
+		err = b.sanityCheckFailed(data, fmt.Sprintf("%d included item(s), down from %d in the previous run", scan.included, state.IncludedCount))
+		if err != nil {
+			return err
+		}
+	}
+
+	fields := make([]string, 0, len(scan.missing))
+	for field := range scan.missing {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		missing := scan.missing[field]
+		if missing*100 > b.config.MaxMissingFieldPercent*scan.included {
+			err = b.sanityCheckFailed(data, fmt.Sprintf("%d of %d included item(s) are missing %s", missing, scan.included, field))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// scan reads every item in a bulk data file, counting how many are included and how many are missing required fields.
+func (i *BulkIngestor[T]) scan(ctx context.Context, path string) (bulkDataScan, error) {
+	scan := bulkDataScan{missing: map[string]int{}}
+
+	file, err := openBulkDataFile(path)
+	if err != nil {
+		return scan, err
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(file)
+
+	// read opening bracket
+	_, err = dec.Token()
+	if err != nil {
+		return scan, err
+	}
+
+	for dec.More() {
+		if scan.items%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return scan, ctx.Err()
+		}
+
+		var raw json.RawMessage
+		err = dec.Decode(&raw)
+		if err != nil {
+			return scan, fmt.Errorf("error reading item %d: %w", scan.items+1, err)
+		}
+		scan.items++
+
+		var item T
+		err = json.Unmarshal(raw, &item)
+		if err != nil {
+			scan.invalid++
+			continue
+		}
+
+		if i.Include != nil && !i.Include(item).Included {
+			continue
+		}
+		scan.included++
+
+		if i.Validate != nil {
+			for _, field := range i.Validate(item) {
+				scan.missing[field]++
+			}
+		}
+	}
+
+	// read closing bracket
+	_, err = dec.Token()
+	if err != nil {
+		return scan, fmt.Errorf("error reading end of file after item %d: %w", scan.items, err)
+	}
+
+	return scan, nil
+}
+
+// sanityCheckFailed returns an error for a failed sanity check, or logs a warning and returns nil if sanity checks are skipped.
+func (b *batchRunner) sanityCheckFailed(data models.ScryfallBulkData, reason string) error {
+	if b.config.SkipSanityChecks {
+		b.logger.Warnf("ignoring failed %s sanity check: %s", data.Type, reason)
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s bulk data file %s: %s", errSanityCheck, data.Type, data.ID, reason)
+}
@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"sync"
+	"time"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+)
+
+// Stages of processing a bulk data file, as recorded in the run history
+const (
+	stageDownload = "download"
+	stageSanity   = "sanity_check"
+	stageIngest   = "ingest"
+	stageMerge    = "merge"
+	stageSweep    = "sweep"
+	stageGenerate = "generate"
+	stageFinish   = "finish"
+)
+
+// ingestStats collects the statistics of processing a bulk data file, which are updated concurrently by the workers
+type ingestStats struct {
+	mu         sync.Mutex
+	data       models.BatchRunBulkData
+	stageStart time.Time
+}
+
+func newIngestStats(bulkType string) *ingestStats {
+	return &ingestStats{
+		data: models.BatchRunBulkData{
+			BulkType: bulkType,
+			Status:   models.RunStatusRunning,
+			Stages:   []models.StageDuration{},
+		},
+		stageStart: time.Now(),
+	}
+}
+
+// update applies the provided change to the statistics.
+func (s *ingestStats) update(change func(data *models.BatchRunBulkData)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	change(&s.data)
+}
+
+// endStage records the time spent in the provided stage, which started when the previous stage ended.
+func (s *ingestStats) endStage(stage string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.data.Stages = append(s.data.Stages, models.StageDuration{
+		Stage:    stage,
+		Duration: now.Sub(s.stageStart).Milliseconds(),
+	})
+	s.stageStart = now
+}
+
+// snapshot returns a copy of the statistics collected so far.
+func (s *ingestStats) snapshot() models.BatchRunBulkData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := s.data
+	data.Stages = append([]models.StageDuration{}, s.data.Stages...)
+
+	return data
+}
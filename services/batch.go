@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/BrandonWade/blackblade-batch/models"
+	"github.com/BrandonWade/blackblade-batch/repositories"
+	"github.com/sirupsen/logrus"
+)
+
+// BatchService interface for working with a batchService
+type BatchService interface {
+	IsBulkDataProcessed(ctx context.Context, data models.ScryfallBulkData) (bool, error)
+	GetBulkDataState(ctx context.Context, data models.ScryfallBulkData) (models.BulkDataState, error)
+	MarkBulkDataProcessed(ctx context.Context, checkpoint models.BulkDataCheckpoint) error
+	GetCheckpoint(ctx context.Context, data models.ScryfallBulkData) (models.BulkDataCheckpoint, error)
+	SaveCheckpoint(ctx context.Context, checkpoint models.BulkDataCheckpoint) error
+	ClearCheckpoint(ctx context.Context, data models.ScryfallBulkData) error
+	RecordFailures(ctx context.Context, failures []models.BatchFailure) error
+	GetUnresolvedFailures(ctx context.Context) ([]models.BatchFailure, error)
+	ResolveFailure(ctx context.Context, id int64) error
+	UpdateFailure(ctx context.Context, id int64, failureErr error) error
+	ResetRuleDecisions(ctx context.Context, data models.ScryfallBulkData) error
+	RecordRuleDecisions(ctx context.Context, decisions []models.RuleDecision) error
+	GenerateRuleReport(ctx context.Context, data models.ScryfallBulkData) (models.RuleReport, error)
+	GetRuleDecisions(ctx context.Context, query string) ([]models.RuleDecision, error)
+	ResetSeenItems(ctx context.Context, data models.ScryfallBulkData) error
+	RecordSeenItems(ctx context.Context, bulkType string, keys []string, excluded bool) error
+	StartRun(ctx context.Context) (int64, error)
+	FinishRun(ctx context.Context, id int64, runErr error) error
+	RecordRunBulkData(ctx context.Context, data models.BatchRunBulkData) error
+	GetRuns(ctx context.Context, limit int) ([]models.BatchRun, error)
+	GetRun(ctx context.Context, id int64) (models.BatchRun, []models.BatchRunBulkData, error)
+}
+
+type batchService struct {
+	logger    *logrus.Logger
+	batchRepo repositories.BatchRepository
+}
+
+// NewBatchService create a new BatchService instance
+func NewBatchService(logger *logrus.Logger, batchRepo repositories.BatchRepository) BatchService {
+	return &batchService{
+		logger,
+		batchRepo,
+	}
+}
+
+// IsBulkDataProcessed returns whether the provided version of a bulk data file has already been successfully processed.
+func (b *batchService) IsBulkDataProcessed(ctx context.Context, data models.ScryfallBulkData) (bool, error) {
+	state, err := b.batchRepo.GetBulkDataState(ctx, data.Type)
+	if err != nil {
+		return false, err
+	}
+
+	return state.BulkID == data.ID && state.UpdatedAt == data.UpdatedAt, nil
+}
+
+// GetBulkDataState returns the last successfully processed version of the provided bulk data file's type.
+func (b *batchService) GetBulkDataState(ctx context.Context, data models.ScryfallBulkData) (models.BulkDataState, error) {
+	return b.batchRepo.GetBulkDataState(ctx, data.Type)
+}
+
+// MarkBulkDataProcessed records the version of the bulk data file the provided checkpoint belongs to as successfully processed.
+func (b *batchService) MarkBulkDataProcessed(ctx context.Context, checkpoint models.BulkDataCheckpoint) error {
+	return b.batchRepo.SaveBulkDataState(ctx, models.BulkDataState{
+		BulkType:      checkpoint.BulkType,
+		BulkID:        checkpoint.BulkID,
+		UpdatedAt:     checkpoint.UpdatedAt,
+		ItemCount:     checkpoint.ItemCount,
+		IncludedCount: checkpoint.IncludedCount,
+	})
+}
+
+// GetCheckpoint returns the saved progress for the provided version of a bulk data file. An empty
+// checkpoint is returned if there is no saved progress or it belongs to a different version of the file.
+func (b *batchService) GetCheckpoint(ctx context.Context, data models.ScryfallBulkData) (models.BulkDataCheckpoint, error) {
+	checkpoint, err := b.batchRepo.GetCheckpoint(ctx, data.Type)
+	if err != nil {
+		return models.BulkDataCheckpoint{}, err
+	}
+
+	if checkpoint.BulkID != data.ID || checkpoint.UpdatedAt != data.UpdatedAt {
+		return models.BulkDataCheckpoint{}, nil
+	}
+
+	return checkpoint, nil
+}
+
+// SaveCheckpoint saves the progress made processing a bulk data file.
+func (b *batchService) SaveCheckpoint(ctx context.Context, checkpoint models.BulkDataCheckpoint) error {
+	return b.batchRepo.SaveCheckpoint(ctx, checkpoint)
+}
+
+// ClearCheckpoint removes the saved progress for the provided bulk data file.
+func (b *batchService) ClearCheckpoint(ctx context.Context, data models.ScryfallBulkData) error {
+	return b.batchRepo.DeleteCheckpoint(ctx, data.Type)
+}
+
+// RecordFailures records the provided failed bulk data items so that they can be replayed later.
+func (b *batchService) RecordFailures(ctx context.Context, failures []models.BatchFailure) error {
+	return b.batchRepo.InsertFailures(ctx, failures)
+}
+
+// GetUnresolvedFailures returns every failed bulk data item that has not yet been successfully replayed.
+func (b *batchService) GetUnresolvedFailures(ctx context.Context) ([]models.BatchFailure, error) {
+	return b.batchRepo.GetUnresolvedFailures(ctx)
+}
+
+// ResolveFailure marks a failed bulk data item as successfully replayed.
+func (b *batchService) ResolveFailure(ctx context.Context, id int64) error {
+	return b.batchRepo.ResolveFailure(ctx, id)
+}
+
+// UpdateFailure records another unsuccessful attempt to replay a failed bulk data item.
+func (b *batchService) UpdateFailure(ctx context.Context, id int64, failureErr error) error {
+	return b.batchRepo.UpdateFailure(ctx, id, failureErr.Error())
+}
+
+// ResetRuleDecisions removes the inclusion rule decisions recorded by the last run of the provided bulk data file.
+func (b *batchService) ResetRuleDecisions(ctx context.Context, data models.ScryfallBulkData) error {
+	return b.batchRepo.DeleteRuleDecisions(ctx, data.Type)
+}
+
+// RecordRuleDecisions records which inclusion rule decided whether each of the provided cards was included.
+func (b *batchService) RecordRuleDecisions(ctx context.Context, decisions []models.RuleDecision) error {
+	return b.batchRepo.UpsertRuleDecisions(ctx, decisions)
+}
+
+// GenerateRuleReport summarizes how many cards each inclusion rule included or excluded while processing
+// the provided bulk data file, and records the summary.
+func (b *batchService) GenerateRuleReport(ctx context.Context, data models.ScryfallBulkData) (models.RuleReport, error) {
+	counts, err := b.batchRepo.GetRuleCounts(ctx, data.Type)
+	if err != nil {
+		return models.RuleReport{}, err
+	}
+
+	report := models.RuleReport{
+		BulkType:  data.Type,
+		BulkID:    data.ID,
+		UpdatedAt: data.UpdatedAt,
+		Rules:     counts,
+	}
+	for _, count := range counts {
+		if count.Included {
+			report.Included += count.Count
+		} else {
+			report.Excluded += count.Count
+		}
+	}
+
+	summary, err := json.Marshal(report)
+	if err != nil {
+		return models.RuleReport{}, err
+	}
+
+	err = b.batchRepo.InsertRuleReport(ctx, report, string(summary))
+	if err != nil {
+		return models.RuleReport{}, err
+	}
+
+	return report, nil
+}
+
+// GetRuleDecisions returns the inclusion rule decisions recorded for cards with the provided scryfall ID or name.
+func (b *batchService) GetRuleDecisions(ctx context.Context, query string) ([]models.RuleDecision, error) {
+	return b.batchRepo.GetRuleDecisions(ctx, query)
+}
+
+// ResetSeenItems removes the items recorded as seen by the last run of the provided bulk data file.
+func (b *batchService) ResetSeenItems(ctx context.Context, data models.ScryfallBulkData) error {
+	return b.batchRepo.DeleteSeenItems(ctx, data.Type)
+}
+
+// RecordSeenItems records the provided item keys as seen in the current run of the specified bulk data type, and
+// whether the items were excluded by the inclusion rules.
+func (b *batchService) RecordSeenItems(ctx context.Context, bulkType string, keys []string, excluded bool) error {
+	return b.batchRepo.InsertSeenItems(ctx, bulkType, keys, excluded)
+}
+
+// StartRun records the start of a new batch run and returns its ID.
+func (b *batchService) StartRun(ctx context.Context) (int64, error) {
+	return b.batchRepo.InsertRun(ctx)
+}
+
+// FinishRun records the end of the specified batch run, which failed if runErr is not nil, or was cancelled if
+// runErr is context.Canceled.
+func (b *batchService) FinishRun(ctx context.Context, id int64, runErr error) error {
+	if errors.Is(runErr, context.Canceled) {
+		return b.batchRepo.FinishRun(ctx, id, models.RunStatusCancelled, runErr.Error())
+	} else if runErr != nil {
+		return b.batchRepo.FinishRun(ctx, id, models.RunStatusFailed, runErr.Error())
+	}
+
+	return b.batchRepo.FinishRun(ctx, id, models.RunStatusSucceeded, "")
+}
+
+// RecordRunBulkData records the statistics of a bulk data file processed by a batch run.
+func (b *batchService) RecordRunBulkData(ctx context.Context, data models.BatchRunBulkData) error {
+	stages, err := json.Marshal(data.Stages)
+	if err != nil {
+		return err
+	}
+
+	data.StageDurations = string(stages)
+	return b.batchRepo.InsertRunBulkData(ctx, data)
+}
+
+// GetRuns returns the most recent batch runs, newest first.
+func (b *batchService) GetRuns(ctx context.Context, limit int) ([]models.BatchRun, error) {
+	return b.batchRepo.GetRuns(ctx, limit)
+}
+
+// GetRun returns the specified batch run along with the statistics of each bulk data file it processed.
+// An empty BatchRun is returned if the run does not exist.
+func (b *batchService) GetRun(ctx context.Context, id int64) (models.BatchRun, []models.BatchRunBulkData, error) {
+	run, err := b.batchRepo.GetRun(ctx, id)
+	if err != nil || run.ID == 0 {
+		return run, []models.BatchRunBulkData{}, err
+	}
+
+	data, err := b.batchRepo.GetRunBulkData(ctx, id)
+	if err != nil {
+		return models.BatchRun{}, []models.BatchRunBulkData{}, err
+	}
+
+	for i := range data {
+		err = json.Unmarshal([]byte(data[i].StageDurations), &data[i].Stages)
+		if err != nil {
+			return models.BatchRun{}, []models.BatchRunBulkData{}, err
+		}
+	}
+
+	return run, data, nil
+}
@@ -1,8 +1,11 @@
 package services
 
 import (
+	"context"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/BrandonWade/blackblade-batch/clients"
 	"github.com/BrandonWade/blackblade-batch/models"
@@ -10,19 +13,57 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Price trends are calculated over each of these windows, in days
+var priceTrendWindows = []int{7, 30, 90}
+
+const (
+	// priceTrendTolerance is how far from the start of a window its start snapshot may have been taken
+	priceTrendTolerance = 12 * time.Hour
+	// suspiciousPriceRatio is how many times a price has to move by, in either direction, to be flagged as suspicious
+	suspiciousPriceRatio = 50
+	// priceMoversPerScope is the number of gainers and losers kept for each set and format
+	priceMoversPerScope = 10
+)
+
 // CardService interface for working with a cardService
 type CardService interface {
-	GetDefaultCards() (models.ScryfallBulkData, error)
-	DownloadDefaultCardData(uri, filepath string) error
-	GetRulings() (models.ScryfallBulkData, error)
-	DownloadRulingsData(uri, filepath string) error
-	UpsertCards(cards []models.ScryfallCard) error
-	GenerateTypes(cards []models.ScryfallCard) error
-	GenerateCardFacesJSON() error
-	GenerateCardSetsJSON() error
-	GenerateSets() error
-	InsertRulings(rulings []models.ScryfallRuling) error
-	GenerateRulingsJSON() error
+	GetBulkData(ctx context.Context, dataType string) (models.ScryfallBulkData, error)
+	DownloadBulkData(ctx context.Context, data models.ScryfallBulkData, filepath string) error
+	UpsertCards(ctx context.Context, cards []models.ScryfallCard) (models.CardWriteStats, error)
+	StageCards(ctx context.Context, cards []models.ScryfallCard) error
+	ResetStagedCards(ctx context.Context) error
+	ResetStagedLegalities(ctx context.Context) error
+	MergeStagedCards(ctx context.Context) (models.CardWriteStats, error)
+	UpsertOracleCards(ctx context.Context, cards []models.ScryfallCard) error
+	GenerateOracleCardsJSON(ctx context.Context, full bool) error
+	UpsertCardArtwork(ctx context.Context, cards []models.ScryfallCard) error
+	GenerateCardArtworkJSON(ctx context.Context) error
+	UpsertCardPrints(ctx context.Context, cards []models.ScryfallCard) error
+	GenerateCardLanguagesJSON(ctx context.Context) error
+	UpsertCardLocalizations(ctx context.Context, cards []models.ScryfallCard) error
+	GenerateTypes(ctx context.Context, cards []models.ScryfallCard) error
+	GenerateCardFacesJSON(ctx context.Context, full bool) error
+	GenerateCardSetsJSON(ctx context.Context, full bool) error
+	GenerateSets(ctx context.Context) error
+	InsertRulings(ctx context.Context, rulings []models.ScryfallRuling) (int64, error)
+	GenerateRulingsJSON(ctx context.Context, full bool) error
+	GenerateLegalities(ctx context.Context) (int64, error)
+	RestoreDerivedTables(ctx context.Context) ([]string, error)
+	RecordPriceSnapshot(ctx context.Context, data models.ScryfallBulkData) error
+	GeneratePriceTrends(ctx context.Context) error
+	RemoveUnseenCards(ctx context.Context, bulkType string, maxPercent int) (int64, error)
+	RemoveUnseenRulings(ctx context.Context, bulkType string, maxPercent int) (int64, error)
+	RestoreCards(ctx context.Context, scryfallIDs []string) error
+	RestoreRulings(ctx context.Context, keys []string) error
+	RemoveUnseenOracleCards(ctx context.Context, bulkType string, maxPercent int) (int64, error)
+	RemoveUnseenCardArtwork(ctx context.Context, bulkType string, maxPercent int) (int64, error)
+	RemoveUnseenCardPrints(ctx context.Context, bulkType string, maxPercent int) (int64, error)
+	RestoreOracleCards(ctx context.Context, oracleIDs []string) error
+	RestoreCardArtwork(ctx context.Context, scryfallIDs []string) error
+	RestoreCardPrints(ctx context.Context, scryfallIDs []string) error
+	ClearChangedCards(ctx context.Context) error
+	ClearChangedRulings(ctx context.Context) error
+	ClearChangedOracleCards(ctx context.Context) error
 }
 
 type cardService struct {
@@ -40,41 +81,105 @@ func NewCardService(logger *logrus.Logger, scryfallClient clients.ScryfallClient
 	}
 }
 
-// GetDefaultCards returns the default_cards bulk data from the Scryfall API.
-func (c *cardService) GetDefaultCards() (models.ScryfallBulkData, error) {
-	return c.scryfallClient.GetBulkData("default-cards")
+// GetBulkData returns the bulk data of the specified type (e.g. default-cards) from the Scryfall API.
+func (c *cardService) GetBulkData(ctx context.Context, dataType string) (models.ScryfallBulkData, error) {
+	return c.scryfallClient.GetBulkData(ctx, dataType)
 }
 
-// DownloadDefaultCardData downloads the default_cards bulk data file from the scryfall API.
-func (c *cardService) DownloadDefaultCardData(uri, filepath string) error {
-	return c.scryfallClient.DownloadBulkData("default-cards", uri, filepath)
+// DownloadBulkData downloads the provided bulk data file from the scryfall API.
+func (c *cardService) DownloadBulkData(ctx context.Context, data models.ScryfallBulkData, filepath string) error {
+	return c.scryfallClient.DownloadBulkData(ctx, data, filepath)
 }
 
-// GetRulings returns the rulings bulk data from the Scryfall API.
-func (c *cardService) GetRulings() (models.ScryfallBulkData, error) {
-	return c.scryfallClient.GetBulkData("rulings")
-}
+// UpsertCards upserts the provided cards into the database and returns the number of cards inserted, changed
+// and unchanged, and the number of stale child rows removed.
+func (c *cardService) UpsertCards(ctx context.Context, cards []models.ScryfallCard) (models.CardWriteStats, error) {
+	err := c.GenerateTypes(ctx, cards)
+	if err != nil {
+		return models.CardWriteStats{}, err
+	}
 
-// DownloadRulingsData downloads the rulings bulk data file from the scryfall API.
-func (c *cardService) DownloadRulingsData(uri, filepath string) error {
-	return c.scryfallClient.DownloadBulkData("rulings", uri, filepath)
+	return c.cardRepo.UpsertCards(ctx, cards)
 }
 
-// UpsertCards upserts the provided cards into the database.
-func (c *cardService) UpsertCards(cards []models.ScryfallCard) error {
-	err := c.GenerateTypes(cards)
+// StageCards loads the provided cards into the staging tables to be merged into the database later.
+func (c *cardService) StageCards(ctx context.Context, cards []models.ScryfallCard) error {
+	err := c.GenerateTypes(ctx, cards)
 	if err != nil {
 		return err
 	}
 
-	return c.cardRepo.UpsertCards(cards)
+	return c.cardRepo.StageCards(ctx, cards)
+}
+
+// ResetStagedCards removes any cards left in the staging tables by a previous run.
+func (c *cardService) ResetStagedCards(ctx context.Context) error {
+	return c.cardRepo.ResetStagedCards(ctx)
+}
+
+// ResetStagedLegalities forgets the legalities observed by the previous run.
+func (c *cardService) ResetStagedLegalities(ctx context.Context) error {
+	return c.cardRepo.ResetStagedLegalities(ctx)
+}
+
+// MergeStagedCards merges every card in the staging tables into the database and returns the number of cards
+// inserted, changed and unchanged, and the number of stale child rows removed.
+func (c *cardService) MergeStagedCards(ctx context.Context) (models.CardWriteStats, error) {
+	return c.cardRepo.MergeStagedCards(ctx)
+}
+
+// UpsertOracleCards upserts the canonical version of each of the provided cards into the database.
+func (c *cardService) UpsertOracleCards(ctx context.Context, cards []models.ScryfallCard) error {
+	return c.cardRepo.UpsertOracleCards(ctx, cards)
+}
+
+// GenerateOracleCardsJSON calculates the JSON for the canonical version of each changed card, or of every card if full is set, and saves the result.
+func (c *cardService) GenerateOracleCardsJSON(ctx context.Context, full bool) error {
+	return c.cardRepo.GenerateOracleCardsJSON(ctx, full)
+}
+
+// UpsertCardArtwork upserts each distinct illustration on the provided cards into the database.
+func (c *cardService) UpsertCardArtwork(ctx context.Context, cards []models.ScryfallCard) error {
+	return c.cardRepo.UpsertCardArtwork(ctx, cards)
+}
+
+// GenerateCardArtworkJSON aggregates the artwork for each distinct card in the database and saves the result.
+func (c *cardService) GenerateCardArtworkJSON(ctx context.Context) error {
+	return c.cardRepo.GenerateCardArtworkJSON(ctx)
+}
+
+// UpsertCardPrints upserts every printing of the provided cards, in any language, into the database.
+func (c *cardService) UpsertCardPrints(ctx context.Context, cards []models.ScryfallCard) error {
+	return c.cardRepo.UpsertCardPrints(ctx, cards)
+}
+
+// GenerateCardLanguagesJSON aggregates the printings in each language for each distinct card in the database and saves the result.
+func (c *cardService) GenerateCardLanguagesJSON(ctx context.Context) error {
+	return c.cardRepo.GenerateCardLanguagesJSON(ctx)
+}
+
+// UpsertCardLocalizations upserts the printed text of any non-English cards in the provided cards into the database.
+func (c *cardService) UpsertCardLocalizations(ctx context.Context, cards []models.ScryfallCard) error {
+	localized := []models.ScryfallCard{}
+	for _, card := range cards {
+		if card.Lang != "en" {
+			localized = append(localized, card)
+		}
+	}
+
+	if len(localized) == 0 {
+		return nil
+	}
+
+	return c.cardRepo.UpsertCardLocalizations(ctx, localized)
 }
 
 // GenerateTypes gets the list of card types from the provided cards and inserts them into the database.
-func (c *cardService) GenerateTypes(cards []models.ScryfallCard) error {
+func (c *cardService) GenerateTypes(ctx context.Context, cards []models.ScryfallCard) error {
 	remove := regexp.MustCompile("(\\s—|//|,|and/or)")
 	spaces := regexp.MustCompile("\\s+")
 
+	seen := map[string]bool{}
 	types := []string{}
 	for _, card := range cards {
 		typeLine := remove.ReplaceAllString(card.TypeLine, "")
@@ -83,36 +188,133 @@ func (c *cardService) GenerateTypes(cards []models.ScryfallCard) error {
 
 		for _, token := range tokens {
 			trimmedToken := strings.TrimSpace(token)
-			if len(trimmedToken) > 0 && trimmedToken != "--" {
+			if len(trimmedToken) > 0 && trimmedToken != "--" && !seen[token] {
+				seen[token] = true
 				types = append(types, token)
 			}
 		}
 	}
 
-	return c.cardRepo.InsertTypes(types)
+	// Insert the types in a consistent order so that concurrent batches don't deadlock on the types index
+	sort.Strings(types)
+
+	return c.cardRepo.InsertTypes(ctx, types)
 }
 
 // GenerateCardFacesJSON calculates the set name and images for each card in the database and saves the result.
-func (c *cardService) GenerateCardFacesJSON() error {
-	return c.cardRepo.GenerateCardFacesJSON()
+// Unless full is set, only the changed cards are recalculated.
+func (c *cardService) GenerateCardFacesJSON(ctx context.Context, full bool) error {
+	return c.cardRepo.GenerateCardFacesJSON(ctx, full)
 }
 
 // GenerateCardSetsJSON aggregates the faces JSON for distinct card in the database and saves the result.
-func (c *cardService) GenerateCardSetsJSON() error {
-	return c.cardRepo.GenerateCardSetsJSON()
+// Unless full is set, only the changed cards are recalculated.
+func (c *cardService) GenerateCardSetsJSON(ctx context.Context, full bool) error {
+	return c.cardRepo.GenerateCardSetsJSON(ctx, full)
 }
 
 // GenerateSets calculates a list of unique set names and codes in the database and saves the result.
-func (c *cardService) GenerateSets() error {
-	return c.cardRepo.GenerateSets()
+func (c *cardService) GenerateSets(ctx context.Context) error {
+	return c.cardRepo.GenerateSets(ctx)
 }
 
 // GenerateRulingsJSON aggregates the rulings for each distinct card in the database and saves the result.
-func (c *cardService) GenerateRulingsJSON() error {
-	return c.cardRepo.GenerateRulingsJSON()
+// Unless full is set, only the cards with changed rulings are recalculated.
+func (c *cardService) GenerateRulingsJSON(ctx context.Context, full bool) error {
+	return c.cardRepo.GenerateRulingsJSON(ctx, full)
+}
+
+// InsertRulings inserts the provided rulings into the database and returns the number of new rulings.
+func (c *cardService) InsertRulings(ctx context.Context, rulings []models.ScryfallRuling) (int64, error) {
+	return c.cardRepo.InsertRulings(ctx, rulings)
+}
+
+// GenerateLegalities publishes the card legalities from the last run, recording any that changed, and returns the number of changes.
+func (c *cardService) GenerateLegalities(ctx context.Context) (int64, error) {
+	return c.cardRepo.GenerateLegalities(ctx)
+}
+
+// RecordPriceSnapshot appends the current price of every card to the price history, keyed by the time the provided bulk data was updated.
+func (c *cardService) RecordPriceSnapshot(ctx context.Context, data models.ScryfallBulkData) error {
+	snapshotAt, err := time.Parse(time.RFC3339, data.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	return c.cardRepo.InsertPriceSnapshot(ctx, snapshotAt.UTC())
+}
+
+// GeneratePriceTrends recalculates the price trends and movers of every card from the price history.
+func (c *cardService) GeneratePriceTrends(ctx context.Context) error {
+	return c.cardRepo.GeneratePriceTrends(ctx, priceTrendWindows, priceTrendTolerance, suspiciousPriceRatio, priceMoversPerScope)
+}
+
+// RemoveUnseenCards marks the cards that were not seen in the current run of the specified bulk data type as removed.
+func (c *cardService) RemoveUnseenCards(ctx context.Context, bulkType string, maxPercent int) (int64, error) {
+	return c.cardRepo.RemoveUnseenCards(ctx, bulkType, maxPercent)
+}
+
+// RemoveUnseenRulings marks the rulings that were not seen in the current run of the specified bulk data type as removed.
+func (c *cardService) RemoveUnseenRulings(ctx context.Context, bulkType string, maxPercent int) (int64, error) {
+	return c.cardRepo.RemoveUnseenRulings(ctx, bulkType, maxPercent)
+}
+
+// RestoreCards restores the removed cards with the provided scryfall IDs.
+func (c *cardService) RestoreCards(ctx context.Context, scryfallIDs []string) error {
+	return c.cardRepo.RestoreCards(ctx, scryfallIDs)
+}
+
+// RestoreRulings restores the removed rulings with the provided keys, each an oracle ID and comment hash.
+func (c *cardService) RestoreRulings(ctx context.Context, keys []string) error {
+	return c.cardRepo.RestoreRulings(ctx, keys)
+}
+
+// RemoveUnseenOracleCards marks the oracle cards that were not seen in the current run of the specified bulk data type as removed.
+func (c *cardService) RemoveUnseenOracleCards(ctx context.Context, bulkType string, maxPercent int) (int64, error) {
+	return c.cardRepo.RemoveUnseenOracleCards(ctx, bulkType, maxPercent)
+}
+
+// RemoveUnseenCardArtwork marks the illustrations whose card was not seen in the current run of the specified bulk data type as removed.
+func (c *cardService) RemoveUnseenCardArtwork(ctx context.Context, bulkType string, maxPercent int) (int64, error) {
+	return c.cardRepo.RemoveUnseenCardArtwork(ctx, bulkType, maxPercent)
+}
+
+// RemoveUnseenCardPrints marks the printings that were not seen in the current run of the specified bulk data type as removed.
+func (c *cardService) RemoveUnseenCardPrints(ctx context.Context, bulkType string, maxPercent int) (int64, error) {
+	return c.cardRepo.RemoveUnseenCardPrints(ctx, bulkType, maxPercent)
+}
+
+// RestoreOracleCards restores the removed oracle cards with the provided oracle IDs.
+func (c *cardService) RestoreOracleCards(ctx context.Context, oracleIDs []string) error {
+	return c.cardRepo.RestoreOracleCards(ctx, oracleIDs)
+}
+
+// RestoreCardArtwork restores the removed illustrations of the cards with the provided scryfall IDs.
+func (c *cardService) RestoreCardArtwork(ctx context.Context, scryfallIDs []string) error {
+	return c.cardRepo.RestoreCardArtwork(ctx, scryfallIDs)
+}
+
+// RestoreCardPrints restores the removed printings with the provided scryfall IDs.
+func (c *cardService) RestoreCardPrints(ctx context.Context, scryfallIDs []string) error {
+	return c.cardRepo.RestoreCardPrints(ctx, scryfallIDs)
+}
+
+// ClearChangedCards forgets the changed cards once the data derived from them has been recalculated.
+func (c *cardService) ClearChangedCards(ctx context.Context) error {
+	return c.cardRepo.ClearChangedCards(ctx)
+}
+
+// ClearChangedRulings forgets the changed rulings once the data derived from them has been recalculated.
+func (c *cardService) ClearChangedRulings(ctx context.Context) error {
+	return c.cardRepo.ClearChangedRulings(ctx)
+}
+
+// ClearChangedOracleCards forgets the changed oracle cards once their JSON has been recalculated.
+func (c *cardService) ClearChangedOracleCards(ctx context.Context) error {
+	return c.cardRepo.ClearChangedOracleCards(ctx)
 }
 
-// InsertRulings inserts the provided rulings into the database.
-func (c *cardService) InsertRulings(rulings []models.ScryfallRuling) error {
-	return c.cardRepo.InsertRulings(rulings)
+// RestoreDerivedTables swaps the previous generation of every derived table back in and returns the tables restored.
+func (c *cardService) RestoreDerivedTables(ctx context.Context) ([]string, error) {
+	return c.cardRepo.RestoreDerivedTables(ctx)
 }